@@ -0,0 +1,137 @@
+// +build windows
+
+package divert
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FlowKey is the 5-tuple of a TCP or UDP packet in the direction of the
+// packet, so that the two directions of a connection are two flows
+type FlowKey struct {
+	Protocol IPProto
+	SrcAddr  netip.Addr
+	DstAddr  netip.Addr
+	SrcPort  uint16
+	DstPort  uint16
+}
+
+// FlowKeyOf returns the FlowKey of a TCP or UDP packet, and false for the
+// other packets
+func FlowKeyOf(p *Packet) (FlowKey, bool) {
+	key := FlowKey{Protocol: p.Protocol}
+	switch {
+	case p.TCP != nil:
+		key.SrcPort, key.DstPort = p.TCP.SrcPort(), p.TCP.DstPort()
+	case p.UDP != nil:
+		key.SrcPort, key.DstPort = p.UDP.SrcPort(), p.UDP.DstPort()
+	default:
+		return key, false
+	}
+
+	if p.IPv4 != nil {
+		key.SrcAddr, key.DstAddr = netip.AddrFrom4(p.IPv4.SrcAddr()), netip.AddrFrom4(p.IPv4.DstAddr())
+	} else {
+		key.SrcAddr, key.DstAddr = netip.AddrFrom16(p.IPv6.SrcAddr()), netip.AddrFrom16(p.IPv6.DstAddr())
+	}
+	return key, true
+}
+
+// Reverse returns the key of the other direction of the flow
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{Protocol: k.Protocol, SrcAddr: k.DstAddr, DstAddr: k.SrcAddr, SrcPort: k.DstPort, DstPort: k.SrcPort}
+}
+
+// FlowStat is the number of packets and bytes of a flow
+type FlowStat struct {
+	Key       FlowKey
+	Packets   uint64
+	Bytes     uint64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Accountant counts the packets and bytes of every TCP and UDP flow, and
+// forgets a flow which is not seen for its ttl
+type Accountant struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	flows map[FlowKey]*FlowStat
+}
+
+// NewAccountant returns an Accountant which forgets a flow after ttl
+func NewAccountant(ttl time.Duration) *Accountant {
+	return &Accountant{
+		ttl:   ttl,
+		flows: make(map[FlowKey]*FlowStat),
+	}
+}
+
+// Add counts a packet, and reports whether it is a TCP or UDP packet
+func (a *Accountant) Add(p *Packet) bool {
+	key, ok := FlowKeyOf(p)
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stat, ok := a.flows[key]
+	if !ok {
+		stat = &FlowStat{Key: key, FirstSeen: now}
+		a.flows[key] = stat
+	}
+	stat.Packets++
+	stat.Bytes += uint64(p.payload + len(p.Payload))
+	stat.LastSeen = now
+	return true
+}
+
+// AddPacket parses packet and counts it
+func (a *Accountant) AddPacket(packet []byte) bool {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return false
+	}
+	return a.Add(p)
+}
+
+// Expire forgets the flows which are not seen for the ttl
+func (a *Accountant) Expire() {
+	now := time.Now()
+
+	a.mu.Lock()
+	for key, stat := range a.flows {
+		if now.Sub(stat.LastSeen) > a.ttl {
+			delete(a.flows, key)
+		}
+	}
+	a.mu.Unlock()
+}
+
+// Top returns up to n flows with the most bytes after forgetting the expired
+// flows, and all the flows when n is less than 1
+func (a *Accountant) Top(n int) []FlowStat {
+	a.Expire()
+
+	a.mu.Lock()
+	stats := make([]FlowStat, 0, len(a.flows))
+	for _, stat := range a.flows {
+		stats = append(stats, *stat)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Bytes > stats[j].Bytes
+	})
+	if n > 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
@@ -83,6 +83,150 @@ func (a *Address) SetEvent(event Event) {
 	a.event = uint8(event)
 }
 
+// NetworkEvent is the event of an address on the network layers
+type NetworkEvent int
+
+const (
+	// NetworkPacket is WINDIVERT_EVENT_NETWORK_PACKET, a packet which is
+	// received with the address, and the only event of the network layers
+	NetworkPacket = NetworkEvent(EventNetworkPacket)
+
+	// NetworkUnknown is any other event, or an address of another layer,
+	// which must not be parsed as a packet
+	NetworkUnknown NetworkEvent = -1
+)
+
+func (e NetworkEvent) String() string {
+	switch e {
+	case NetworkPacket:
+		return "WINDIVERT_EVENT_NETWORK_PACKET"
+	default:
+		return ""
+	}
+}
+
+// NetworkEvent returns the event of an address on the network layers, so that
+// only NetworkPacket is parsed as a packet
+func (a *Address) NetworkEvent() NetworkEvent {
+	switch a.Layer() {
+	case LayerNetwork, LayerNetworkForward:
+		if a.Event() == EventNetworkPacket {
+			return NetworkPacket
+		}
+	}
+	return NetworkUnknown
+}
+
+const (
+	flagSniffed     = 1 << 0
+	flagOutbound    = 1 << 1
+	flagLoopback    = 1 << 2
+	flagImpostor    = 1 << 3
+	flagIPv6        = 1 << 4
+	flagIPChecksum  = 1 << 5
+	flagTCPChecksum = 1 << 6
+	flagUDPChecksum = 1 << 7
+)
+
+func (a *Address) flag(f uint8) bool {
+	return a.Flags&f != 0
+}
+
+func (a *Address) setFlag(f uint8, b bool) {
+	if b {
+		a.Flags |= f
+	} else {
+		a.Flags &^= f
+	}
+}
+
+func (a *Address) Sniffed() bool {
+	return a.flag(flagSniffed)
+}
+
+func (a *Address) Outbound() bool {
+	return a.flag(flagOutbound)
+}
+
+func (a *Address) SetOutbound(b bool) {
+	a.setFlag(flagOutbound, b)
+}
+
+// Direction returns the direction of the packet
+func (a *Address) Direction() Direction {
+	if a.Outbound() {
+		return Outbound
+	}
+	return Inbound
+}
+
+// SetDirection sets the Outbound flag for the direction
+func (a *Address) SetDirection(d Direction) {
+	a.SetOutbound(d == Outbound)
+}
+
+func (a *Address) Loopback() bool {
+	return a.flag(flagLoopback)
+}
+
+func (a *Address) SetLoopback(b bool) {
+	a.setFlag(flagLoopback, b)
+}
+
+// Impostor reports whether the packet was injected by another driver, such as
+// another WinDivert handle with the Impostor flag set, rather than originating
+// from the network or the TCP/IP stack of Windows
+func (a *Address) Impostor() bool {
+	return a.flag(flagImpostor)
+}
+
+// SetImpostor sets whether an injected packet is an impostor. A packet sent
+// by a handle is not diverted again by the same handle or handles of higher
+// priorities, but an impostor packet can be diverted by every handle, the
+// same one included. Set it when re-injecting a packet that was received as
+// an impostor, or when a packet must be seen by every handle again, and keep
+// "not impostor" in the filter of the handle to avoid infinite loops. As a
+// last resort WinDivert decrements the TTL or HopLimit of impostor packets,
+// and Send fails with ErrHostUnreachable when it reaches zero.
+func (a *Address) SetImpostor(b bool) {
+	a.setFlag(flagImpostor, b)
+}
+
+func (a *Address) IPv6() bool {
+	return a.flag(flagIPv6)
+}
+
+func (a *Address) SetIPv6(b bool) {
+	a.setFlag(flagIPv6, b)
+}
+
+// IPChecksum reports whether the IPv4 checksum of the packet is valid
+func (a *Address) IPChecksum() bool {
+	return a.flag(flagIPChecksum)
+}
+
+func (a *Address) SetIPChecksum(b bool) {
+	a.setFlag(flagIPChecksum, b)
+}
+
+// TCPChecksum reports whether the TCP checksum of the packet is valid
+func (a *Address) TCPChecksum() bool {
+	return a.flag(flagTCPChecksum)
+}
+
+func (a *Address) SetTCPChecksum(b bool) {
+	a.setFlag(flagTCPChecksum, b)
+}
+
+// UDPChecksum reports whether the UDP checksum of the packet is valid
+func (a *Address) UDPChecksum() bool {
+	return a.flag(flagUDPChecksum)
+}
+
+func (a *Address) SetUDPChecksum(b bool) {
+	a.setFlag(flagUDPChecksum, b)
+}
+
 func (a *Address) Length() uint32 {
 	return a.length >> 12
 }
@@ -110,3 +254,48 @@ func (a *Address) Flow() *Flow {
 func (a *Address) Reflect() *Reflect {
 	return (*Reflect)(unsafe.Pointer(&a.union))
 }
+
+// RemapInterface sets the interface index of an address on the network layers
+// to newIndex, and the sub-interface index to 0, so that a packet captured on
+// another host is injected on a local interface. The addresses of the other
+// layers are left untouched.
+func RemapInterface(address *Address, newIndex uint32) {
+	switch address.Layer() {
+	case LayerNetwork, LayerNetworkForward:
+		address.Network().InterfaceIndex = newIndex
+		address.Network().SubInterfaceIndex = 0
+	}
+}
+
+// RemapAll calls RemapInterface for every address
+func RemapAll(addresses []Address, newIndex uint32) {
+	for i := range addresses {
+		RemapInterface(&addresses[i], newIndex)
+	}
+}
+
+// The layouts above must match WINDIVERT_ADDRESS in windivert.h, following
+// the static checks of WinDivertOpen in windivert.c. The declarations below
+// fail to compile when a size or an offset is changed.
+var (
+	_ [unsafe.Sizeof(Address{}) - 80]struct{}
+	_ [80 - unsafe.Sizeof(Address{})]struct{}
+	_ [unsafe.Offsetof(Address{}.union) - 16]struct{}
+	_ [16 - unsafe.Offsetof(Address{}.union)]struct{}
+	_ [unsafe.Sizeof(Ethernet{}) - 64]struct{}
+	_ [64 - unsafe.Sizeof(Ethernet{})]struct{}
+	_ [unsafe.Sizeof(Network{}) - 64]struct{}
+	_ [64 - unsafe.Sizeof(Network{})]struct{}
+	_ [unsafe.Sizeof(Flow{}) - 64]struct{}
+	_ [64 - unsafe.Sizeof(Flow{})]struct{}
+	_ [unsafe.Offsetof(Flow{}.Protocol) - 56]struct{}
+	_ [56 - unsafe.Offsetof(Flow{}.Protocol)]struct{}
+	_ [unsafe.Sizeof(Socket{}) - 64]struct{}
+	_ [64 - unsafe.Sizeof(Socket{})]struct{}
+	_ [unsafe.Offsetof(Socket{}.Protocol) - 56]struct{}
+	_ [56 - unsafe.Offsetof(Socket{}.Protocol)]struct{}
+	_ [unsafe.Sizeof(Reflect{}) - 64]struct{}
+	_ [64 - unsafe.Sizeof(Reflect{})]struct{}
+	_ [unsafe.Offsetof(Reflect{}.Priority) - 24]struct{}
+	_ [24 - unsafe.Offsetof(Reflect{}.Priority)]struct{}
+)
@@ -0,0 +1,153 @@
+// +build windows
+
+package divert
+
+import "sync"
+
+// SendResult is the result of a packet queued by AsyncSender.Send
+type SendResult struct {
+	Token interface{}
+	Err   error
+}
+
+type asyncPacket struct {
+	packet  []byte
+	address Address
+	token   interface{}
+}
+
+// AsyncSender injects queued packets in batches of up to BatchMax packets with
+// SendEx from its own goroutine, and reports the result of every packet with
+// its token on Results
+type AsyncSender struct {
+	h       *Handle
+	queue   chan asyncPacket
+	results chan SendResult
+	wg      sync.WaitGroup
+
+	// queueMu is held to queue a packet, and for writing to close the queue
+	queueMu sync.RWMutex
+	closed  bool
+
+	// pending counts the packets which are queued and not sent, and err is
+	// the first error since the last Flush
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int
+	err     error
+}
+
+// NewAsyncSender returns an AsyncSender which queues up to n packets. Results
+// must be received, or the sender stops when the results are not consumed.
+func NewAsyncSender(h *Handle, n int) *AsyncSender {
+	s := &AsyncSender{
+		h:       h,
+		queue:   make(chan asyncPacket, n),
+		results: make(chan SendResult, n),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Send queues a packet, which must not be modified before its result is
+// reported, and returns ErrClosed after Close
+func (s *AsyncSender) Send(packet []byte, address *Address, token interface{}) error {
+	s.queueMu.RLock()
+	defer s.queueMu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.mu.Lock()
+	s.pending++
+	s.mu.Unlock()
+
+	s.queue <- asyncPacket{packet: packet, address: *address, token: token}
+	return nil
+}
+
+// Results returns the channel of results, which is closed after Close
+func (s *AsyncSender) Results() <-chan SendResult {
+	return s.results
+}
+
+// Flush waits until all the packets queued so far are sent and their results
+// are reported, and returns the first error of SendEx since the last Flush.
+// Results must be received meanwhile, or Flush never returns.
+func (s *AsyncSender) Flush() error {
+	s.mu.Lock()
+	for s.pending > 0 {
+		s.cond.Wait()
+	}
+	err := s.err
+	s.err = nil
+	s.mu.Unlock()
+	return err
+}
+
+// Close injects all the queued packets and stops the sender, and a second
+// Close does nothing
+func (s *AsyncSender) Close() {
+	s.queueMu.Lock()
+	if s.closed {
+		s.queueMu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.queueMu.Unlock()
+
+	s.wg.Wait()
+	close(s.results)
+}
+
+func (s *AsyncSender) run() {
+	defer s.wg.Done()
+
+	batch := make([]asyncPacket, 0, BatchMax)
+	buffer := make([]byte, 0, MTUMax)
+	address := make([]Address, 0, BatchMax)
+
+	for p := range s.queue {
+		batch = append(batch[:0], p)
+	coalesce:
+		for len(batch) < BatchMax {
+			select {
+			case p, ok := <-s.queue:
+				if !ok {
+					break coalesce
+				}
+				batch = append(batch, p)
+			default:
+				break coalesce
+			}
+		}
+
+		buffer, address = buffer[:0], address[:0]
+		for i := range batch {
+			buffer = append(buffer, batch[i].packet...)
+			address = append(address, batch[i].address)
+		}
+
+		_, err := s.h.SendEx(buffer, address)
+		for i := range batch {
+			s.results <- SendResult{Token: batch[i].token, Err: err}
+			batch[i] = asyncPacket{}
+		}
+
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.pending -= len(batch)
+		if s.pending == 0 {
+			s.cond.Broadcast()
+		}
+		s.mu.Unlock()
+	}
+}
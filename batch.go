@@ -0,0 +1,122 @@
+// +build windows
+
+package divert
+
+// BatchEditor holds the packets of a batch received by RecvEx, each with its
+// own address, so that packets are modified, resized or dropped one by one
+// and the batch is sent by SendEx with every address still matched to its
+// packet
+type BatchEditor struct {
+	packets [][]byte
+	address []Address
+	buffer  []byte
+}
+
+// NewBatchEditor splits buffer, the first iolen bytes received by RecvEx,
+// into a packet for every address by the lengths of the IP headers. The
+// packets share the memory of buffer until they are replaced by Set, and the
+// addresses are copied.
+func NewBatchEditor(buffer []byte, address []Address) (*BatchEditor, error) {
+	e := &BatchEditor{
+		packets: make([][]byte, 0, len(address)),
+		address: append([]Address(nil), address...),
+	}
+
+	for len(buffer) > 0 {
+		n, err := ipLength(buffer)
+		if err != nil {
+			return nil, err
+		}
+		// a packet of no length would never be split off
+		if n == 0 {
+			return nil, errPacketHeader
+		}
+		e.packets = append(e.packets, buffer[:n:n])
+		buffer = buffer[n:]
+	}
+	if len(e.packets) != len(e.address) {
+		return nil, errBatchPackets
+	}
+	return e, nil
+}
+
+// ipLength returns the length of the IP packet at the start of b
+func ipLength(b []byte) (int, error) {
+	if len(b) < 20 {
+		return 0, errPacketShort
+	}
+
+	n := 0
+	switch b[0] >> 4 {
+	case 4:
+		n = int(IPv4Header(b).Length())
+		if hdrLen := IPv4Header(b).HdrLength(); hdrLen < 20 || n < hdrLen {
+			return 0, errPacketHeader
+		}
+	case 6:
+		if len(b) < 40 {
+			return 0, errPacketShort
+		}
+		n = 40 + int(IPv6Header(b).PayloadLength())
+	default:
+		return 0, errPacketVersion
+	}
+	if n > len(b) {
+		return 0, errPacketShort
+	}
+	return n, nil
+}
+
+// Len returns the number of packets, including the dropped ones
+func (e *BatchEditor) Len() int {
+	return len(e.packets)
+}
+
+// Packet returns packet i, which is nil when it is dropped
+func (e *BatchEditor) Packet(i int) []byte {
+	return e.packets[i]
+}
+
+// Address returns the address of packet i, which may be modified in place
+func (e *BatchEditor) Address(i int) *Address {
+	return &e.address[i]
+}
+
+// Set replaces packet i with packet, which may differ in length, and keeps
+// its address. An empty packet drops it.
+func (e *BatchEditor) Set(i int, packet []byte) {
+	e.packets[i] = packet
+}
+
+// Drop drops packet i and its address, and leaves the indexes of the other
+// packets untouched
+func (e *BatchEditor) Drop(i int) {
+	e.packets[i] = nil
+}
+
+// Pack returns the packets which are not dropped in order, joined in a buffer
+// of the editor, and their addresses, as SendEx takes them. The buffer is
+// reused by the next Pack.
+func (e *BatchEditor) Pack() ([]byte, []Address) {
+	buffer := e.buffer[:0]
+	address := make([]Address, 0, len(e.address))
+	for i, p := range e.packets {
+		if len(p) == 0 {
+			continue
+		}
+		buffer = append(buffer, p...)
+		address = append(address, e.address[i])
+	}
+	e.buffer = buffer
+	return buffer, address
+}
+
+// Send sends the packets which are not dropped with SendEx, and sends nothing
+// when every packet is dropped
+func (e *BatchEditor) Send(h *Handle) (uint, error) {
+	buffer, address := e.Pack()
+	if len(address) == 0 {
+		return 0, nil
+	}
+	return h.SendEx(buffer, address)
+}
@@ -0,0 +1,29 @@
+// +build windows
+
+package divert
+
+import "testing"
+
+func TestNewBatchEditor(t *testing.T) {
+	udp, tcp := readPacket(t, "ipv4-udp-dns"), readPacket(t, "ipv6-tcp-http")
+	buffer := append(append([]byte(nil), udp...), tcp...)
+
+	e, err := NewBatchEditor(buffer, make([]Address, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Len() != 2 || len(e.Packet(0)) != len(udp) || len(e.Packet(1)) != len(tcp) {
+		t.Errorf("batch is split into %v packets", e.Len())
+	}
+}
+
+// TestNewBatchEditorZeroLength checks that an IPv4 header whose total length
+// and IHL are 0 is rejected rather than split off forever
+func TestNewBatchEditorZeroLength(t *testing.T) {
+	buffer := make([]byte, 20)
+	buffer[0] = 4 << 4
+
+	if _, err := NewBatchEditor(buffer, make([]Address, 1)); err != errPacketHeader {
+		t.Errorf("NewBatchEditor returns %v, want %v", err, errPacketHeader)
+	}
+}
@@ -0,0 +1,107 @@
+// +build windows
+
+package divert
+
+import "sync/atomic"
+
+// adaptiveWindow is the number of packets after which AdaptiveBuffer sizes
+// the buffer again
+const adaptiveWindow = 1024
+
+// AdaptiveBuffer is a receive buffer which is sized by the packets received
+// into it. It starts at min bytes, doubles up to MTUMax when a packet does
+// not fit, and after every 1024 packets shrinks to the smallest doubling of
+// the minimum size which fits the largest of them. A packet which does not fit is truncated by the
+// driver and lost, so min should fit the usual packets.
+type AdaptiveBuffer struct {
+	min     int
+	size    int64
+	buffer  []byte
+	count   int
+	largest int
+}
+
+// NewAdaptiveBuffer returns an AdaptiveBuffer of min bytes
+func NewAdaptiveBuffer(min int) *AdaptiveBuffer {
+	if min < 1 || min > MTUMax {
+		min = MTUMax
+	}
+	return &AdaptiveBuffer{
+		min:    min,
+		size:   int64(min),
+		buffer: make([]byte, min),
+	}
+}
+
+// Size returns the current size of the buffer, and it is safe to call while
+// the buffer is used by another goroutine
+func (b *AdaptiveBuffer) Size() int {
+	return int(atomic.LoadInt64(&b.size))
+}
+
+// Bytes returns the buffer to receive into
+func (b *AdaptiveBuffer) Bytes() []byte {
+	return b.buffer
+}
+
+// Observe records a packet of n bytes received into the buffer
+func (b *AdaptiveBuffer) Observe(n int) {
+	if n > b.largest {
+		b.largest = n
+	}
+	if b.count++; b.count < adaptiveWindow {
+		return
+	}
+
+	size := b.min
+	for size < b.largest && size < MTUMax {
+		size *= 2
+	}
+	b.resize(size)
+	b.count, b.largest = 0, 0
+}
+
+// Grow doubles the buffer after a receive fails with ErrInsufficientBuffer,
+// and reports whether it grows
+func (b *AdaptiveBuffer) Grow() bool {
+	if len(b.buffer) >= MTUMax {
+		return false
+	}
+	b.resize(len(b.buffer) * 2)
+	return true
+}
+
+func (b *AdaptiveBuffer) resize(size int) {
+	if size > MTUMax {
+		size = MTUMax
+	}
+	if size == len(b.buffer) {
+		return
+	}
+	b.buffer = make([]byte, size)
+	atomic.StoreInt64(&b.size, int64(size))
+}
+
+// ForEachAdaptive is ForEach which receives into an AdaptiveBuffer, and a
+// packet which does not fit is dropped after growing the buffer
+func (h *Handle) ForEachAdaptive(handler Handler, buffer *AdaptiveBuffer) error {
+	address := new(Address)
+
+	for {
+		n, err := h.Recv(buffer.Bytes(), address)
+		if err != nil {
+			if err == ErrNoData {
+				return nil
+			}
+			if err == ErrInsufficientBuffer && buffer.Grow() {
+				continue
+			}
+			return err
+		}
+		buffer.Observe(int(n))
+
+		if err := handler(buffer.Bytes()[:n], address); err != nil {
+			return err
+		}
+	}
+}
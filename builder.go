@@ -0,0 +1,217 @@
+// +build windows
+
+package divert
+
+import "net/netip"
+
+// PacketBuilder builds a TCP or UDP packet from scratch, with its checksums
+// and the address to send it. The setters return the builder, and an address
+// which is not of the IP version of the builder is left zero.
+type PacketBuilder struct {
+	ipv6  bool
+	proto IPProto
+
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+	ttl              uint8
+
+	seq, ack uint32
+	flags    TCPFlags
+	window   uint16
+
+	payload []byte
+
+	direction          Direction
+	ifIdx, subIfIdx    uint32
+	loopback, impostor bool
+}
+
+func newPacketBuilder(ipv6 bool, proto IPProto) *PacketBuilder {
+	return &PacketBuilder{
+		ipv6:      ipv6,
+		proto:     proto,
+		ttl:       64,
+		window:    65535,
+		direction: Outbound,
+	}
+}
+
+// NewIPv4TCP returns a builder of an IPv4 TCP packet
+func NewIPv4TCP() *PacketBuilder {
+	return newPacketBuilder(false, ProtoTCP)
+}
+
+// NewIPv4UDP returns a builder of an IPv4 UDP packet
+func NewIPv4UDP() *PacketBuilder {
+	return newPacketBuilder(false, ProtoUDP)
+}
+
+// NewIPv6TCP returns a builder of an IPv6 TCP packet
+func NewIPv6TCP() *PacketBuilder {
+	return newPacketBuilder(true, ProtoTCP)
+}
+
+// NewIPv6UDP returns a builder of an IPv6 UDP packet
+func NewIPv6UDP() *PacketBuilder {
+	return newPacketBuilder(true, ProtoUDP)
+}
+
+// Src sets the source address and port
+func (b *PacketBuilder) Src(addr netip.Addr, port uint16) *PacketBuilder {
+	b.src, b.srcPort = addr, port
+	return b
+}
+
+// Dst sets the destination address and port
+func (b *PacketBuilder) Dst(addr netip.Addr, port uint16) *PacketBuilder {
+	b.dst, b.dstPort = addr, port
+	return b
+}
+
+// TTL sets the TTL of IPv4 or the hop limit of IPv6, which is 64 by default
+func (b *PacketBuilder) TTL(ttl uint8) *PacketBuilder {
+	b.ttl = ttl
+	return b
+}
+
+// Seq sets the sequence number of TCP
+func (b *PacketBuilder) Seq(seq uint32) *PacketBuilder {
+	b.seq = seq
+	return b
+}
+
+// Ack sets the acknowledgment number of TCP, and the TCPAck flag
+func (b *PacketBuilder) Ack(ack uint32) *PacketBuilder {
+	b.ack = ack
+	b.flags |= TCPAck
+	return b
+}
+
+// Flags sets the flags of TCP
+func (b *PacketBuilder) Flags(flags TCPFlags) *PacketBuilder {
+	b.flags = flags
+	return b
+}
+
+// Window sets the window of TCP, which is 65535 by default
+func (b *PacketBuilder) Window(window uint16) *PacketBuilder {
+	b.window = window
+	return b
+}
+
+// Payload sets the payload, which is copied by Build
+func (b *PacketBuilder) Payload(payload []byte) *PacketBuilder {
+	b.payload = payload
+	return b
+}
+
+// Direction sets the direction of the address, which is Outbound by default
+func (b *PacketBuilder) Direction(d Direction) *PacketBuilder {
+	b.direction = d
+	return b
+}
+
+// Interface sets the interface and sub-interface indexes of the address,
+// which an inbound packet is injected on
+func (b *PacketBuilder) Interface(ifIdx, subIfIdx uint32) *PacketBuilder {
+	b.ifIdx, b.subIfIdx = ifIdx, subIfIdx
+	return b
+}
+
+// Loopback sets the loopback flag of the address
+func (b *PacketBuilder) Loopback(loopback bool) *PacketBuilder {
+	b.loopback = loopback
+	return b
+}
+
+// Impostor sets the impostor flag of the address
+func (b *PacketBuilder) Impostor(impostor bool) *PacketBuilder {
+	b.impostor = impostor
+	return b
+}
+
+// Build returns the packet with its checksums and the address to send it on
+// the network layer
+func (b *PacketBuilder) Build() ([]byte, *Address) {
+	ipLen, transLen := 20, 20
+	if b.ipv6 {
+		ipLen = 40
+	}
+	if b.proto == ProtoUDP {
+		transLen = 8
+	}
+	n := ipLen + transLen + len(b.payload)
+
+	packet := make([]byte, n)
+	if b.ipv6 {
+		packet[0] = 0x60
+		ip := IPv6Header(packet)
+		ip.SetPayloadLength(uint16(n - ipLen))
+		packet[6] = byte(b.proto)
+		ip.SetHopLimit(b.ttl)
+		ip.SetSrcAddr(as16(b.src))
+		ip.SetDstAddr(as16(b.dst))
+	} else {
+		packet[0] = 0x45
+		ip := IPv4Header(packet)
+		ip.SetLength(uint16(n))
+		ip.SetTTL(b.ttl)
+		packet[9] = byte(b.proto)
+		ip.SetSrcAddr(as4(b.src))
+		ip.SetDstAddr(as4(b.dst))
+	}
+
+	if b.proto == ProtoTCP {
+		tcp := TCPHeader(packet[ipLen:])
+		tcp.SetSrcPort(b.srcPort)
+		tcp.SetDstPort(b.dstPort)
+		tcp.SetSeqNum(b.seq)
+		tcp.SetAckNum(b.ack)
+		tcp[12] = 5 << 4
+		tcp.SetFlags(b.flags)
+		tcp.SetWindow(b.window)
+	} else {
+		udp := UDPHeader(packet[ipLen:])
+		udp.SetSrcPort(b.srcPort)
+		udp.SetDstPort(b.dstPort)
+		udp.SetLength(uint16(transLen + len(b.payload)))
+	}
+	copy(packet[ipLen+transLen:], b.payload)
+
+	if p, err := ParsePacket(packet); err == nil {
+		p.CalcChecksums(ChecksumDefault)
+	}
+
+	address := new(Address)
+	address.SetLayer(LayerNetwork)
+	address.SetEvent(EventNetworkPacket)
+	address.SetDirection(b.direction)
+	address.SetLoopback(b.loopback)
+	address.SetImpostor(b.impostor)
+	address.SetIPv6(b.ipv6)
+	address.SetIPChecksum(!b.ipv6)
+	address.SetTCPChecksum(b.proto == ProtoTCP)
+	address.SetUDPChecksum(b.proto == ProtoUDP)
+	address.Network().InterfaceIndex = b.ifIdx
+	address.Network().SubInterfaceIndex = b.subIfIdx
+
+	return packet, address
+}
+
+// as4 returns the IPv4 address of addr, and the zero address for an address
+// which is not IPv4
+func as4(addr netip.Addr) (b [4]byte) {
+	if addr = addr.Unmap(); addr.Is4() {
+		b = addr.As4()
+	}
+	return
+}
+
+// as16 returns the IPv6 address of addr, and the zero address for an address
+// which is not IPv6
+func as16(addr netip.Addr) (b [16]byte) {
+	if addr.Is6() {
+		b = addr.As16()
+	}
+	return
+}
@@ -0,0 +1,57 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"time"
+)
+
+// CaptureFor sniffs the packets of filter on layer for a duration of d or
+// until max packets are captured, and max less than 1 means no limit. The
+// handle is opened with FlagSniff and FlagRecvOnly, so that the packets go
+// on, and is closed before CaptureFor returns.
+func CaptureFor(filter string, layer Layer, d time.Duration, max int) ([]CapturedPacket, error) {
+	return CaptureForContext(context.Background(), filter, layer, d, max)
+}
+
+// CaptureForContext is CaptureFor which also stops when ctx is done, and
+// returns the packets captured until then with the error of ctx
+func CaptureForContext(ctx context.Context, filter string, layer Layer, d time.Duration, max int) ([]CapturedPacket, error) {
+	h, err := Open(filter, layer, PriorityDefault, FlagSniff|FlagRecvOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	rctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	c, err := newCancelEvent(rctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	buffer := make([]byte, MTUMax)
+	address := Address{}
+
+	packets := []CapturedPacket(nil)
+	for max < 1 || len(packets) < max {
+		n, err := h.recvCancel(rctx, c, buffer, &address)
+		if err != nil {
+			if err == context.DeadlineExceeded && ctx.Err() == nil {
+				break
+			}
+			return packets, err
+		}
+
+		packets = append(packets, CapturedPacket{
+			Data:    append([]byte(nil), buffer[:n]...),
+			Address: address,
+			Time:    time.Now(),
+		})
+	}
+
+	return packets, nil
+}
@@ -0,0 +1,125 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"sync"
+)
+
+// ChainPriorities returns n descending priorities starting from
+// PriorityHighest. A packet is diverted by the handle with the highest
+// priority first, and a packet re-injected by it with Send is diverted again
+// by the handle with the next lower priority, so the i-th priority is for the
+// i-th stage of a pipeline.
+func ChainPriorities(n int) []int16 {
+	if n < 0 || n > int(PriorityHighest)-int(PriorityLowest)+1 {
+		panic("divert: number of priorities is out of range")
+	}
+
+	priorities := make([]int16, n)
+	for i := range priorities {
+		priorities[i] = int16(int(PriorityHighest) - i)
+	}
+	return priorities
+}
+
+// Stage processes a packet diverted by a handle of a Chain. The packet is
+// re-injected to the next stage if it returns true, or dropped otherwise.
+type Stage func(packet []byte, address *Address) bool
+
+// Chain is a pipeline of handles opened with the same filter and priorities
+// from ChainPriorities, so that every packet passes all the stages in order.
+type Chain struct {
+	handles []*Handle
+	stages  []Stage
+	wg      sync.WaitGroup
+}
+
+// NewChain opens a handle for every stage
+func NewChain(filter string, layer Layer, flags uint64, stages ...Stage) (*Chain, error) {
+	c := &Chain{
+		handles: make([]*Handle, 0, len(stages)),
+		stages:  stages,
+	}
+
+	for _, priority := range ChainPriorities(len(stages)) {
+		h, err := Open(filter, layer, priority, flags)
+		if err != nil {
+			for _, h := range c.handles {
+				h.Close()
+			}
+			return nil, err
+		}
+		c.handles = append(c.handles, h)
+	}
+
+	return c, nil
+}
+
+// Run runs all the stages until the chain is closed, and returns the first
+// error of the stages, which stops the other stages
+func (c *Chain) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext is Run which also stops all the stages when ctx is done, and
+// returns the error of ctx then
+func (c *Chain) RunContext(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// an error is sent before it cancels the other stages, so the first error
+	// is not the error of ctx of a stage it stops
+	errCh := make(chan error, len(c.stages))
+
+	c.wg.Add(len(c.stages))
+	for i := range c.stages {
+		go func(h *Handle, stage Stage) {
+			defer c.wg.Done()
+			err := c.run(ctx, h, stage)
+			errCh <- err
+			if err != nil {
+				cancel()
+			}
+		}(c.handles[i], c.stages[i])
+	}
+	c.wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Chain) run(ctx context.Context, h *Handle, stage Stage) error {
+	return h.ForEachContext(ctx, func(packet []byte, address *Address) error {
+		if !stage(packet, address) {
+			return nil
+		}
+
+		_, err := h.Send(packet, address)
+		return err
+	})
+}
+
+// Close shuts down the receive of all the handles, waits for Run to return
+// and closes them. The stages drain the packets which are already queued and
+// send them on, so that no packet is lost in the chain.
+func (c *Chain) Close() error {
+	for _, h := range c.handles {
+		h.Shutdown(ShutdownRecv)
+	}
+	c.wg.Wait()
+
+	var err error
+	for _, h := range c.handles {
+		if er := h.Close(); er != nil && err == nil {
+			err = er
+		}
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+// +build windows
+
+package divert
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ClassifiedPacket is a captured packet with the index of the first rule of a
+// Classifier which it matches, and Rule is -1 when it matches no rule
+type ClassifiedPacket struct {
+	CapturedPacket
+	Rule int
+}
+
+// Classifier tags packets with the first of an ordered list of rules which
+// they match, evaluated with EvalFilter, and counts the packets of every rule
+// for per-rule accounting. A handle usually captures the packets of all the
+// rules with the filter returned by MergeFilters.
+type Classifier struct {
+	objects []string
+	counts  []uint64
+	misses  uint64
+}
+
+// NewClassifier compiles rules on layer once, and returns a Classifier
+func NewClassifier(rules []string, layer Layer) (*Classifier, error) {
+	c := &Classifier{
+		objects: make([]string, len(rules)),
+		counts:  make([]uint64, len(rules)),
+	}
+	for i, rule := range rules {
+		object, err := CompileFilter(rule, layer)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: %w", i, err)
+		}
+		c.objects[i] = object
+	}
+	return c, nil
+}
+
+// Classify returns the index of the first rule which packet and address
+// match, and -1 when they match no rule
+func (c *Classifier) Classify(packet []byte, address *Address) (int, error) {
+	for i, object := range c.objects {
+		ok, err := EvalFilter(object, packet, address)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			atomic.AddUint64(&c.counts[i], 1)
+			return i, nil
+		}
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return -1, nil
+}
+
+// Handler returns a Handler which classifies every packet and passes a copy
+// of it with its rule to next, so that h.ForEach(c.Handler(next)) runs the
+// classifier
+func (c *Classifier) Handler(next func(ClassifiedPacket) error) Handler {
+	return func(packet []byte, address *Address) error {
+		rule, err := c.Classify(packet, address)
+		if err != nil {
+			return err
+		}
+		return next(ClassifiedPacket{
+			CapturedPacket: CapturedPacket{
+				Data:    append([]byte(nil), packet...),
+				Address: *address,
+				Time:    time.Now(),
+			},
+			Rule: rule,
+		})
+	}
+}
+
+// Counts returns the number of packets which every rule matches, and the
+// number of packets which no rule matches
+func (c *Classifier) Counts() ([]uint64, uint64) {
+	counts := make([]uint64, len(c.counts))
+	for i := range c.counts {
+		counts[i] = atomic.LoadUint64(&c.counts[i])
+	}
+	return counts, atomic.LoadUint64(&c.misses)
+}
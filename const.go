@@ -2,6 +2,11 @@
 
 package divert
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Layer int
 
 func (l Layer) String() string {
@@ -23,6 +28,26 @@ func (l Layer) String() string {
 	}
 }
 
+// ParseLayer returns the layer of name, which is one of network,
+// network_forward, flow, socket and reflect in any case, or the name
+// returned by Layer.String
+func ParseLayer(name string) (Layer, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, "WINDIVERT_LAYER_")) {
+	case "network":
+		return LayerNetwork, nil
+	case "network_forward", "network-forward", "forward":
+		return LayerNetworkForward, nil
+	case "flow":
+		return LayerFlow, nil
+	case "socket":
+		return LayerSocket, nil
+	case "reflect":
+		return LayerReflect, nil
+	default:
+		return 0, fmt.Errorf("Layer %q is unknown", name)
+	}
+}
+
 type Event int
 
 func (e Event) String() string {
@@ -87,3 +112,23 @@ func (p Param) String() string {
 		return ""
 	}
 }
+
+// Direction is the direction of a packet, which is set by the Outbound flag
+// of its address
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "Inbound"
+	case Outbound:
+		return "Outbound"
+	default:
+		return ""
+	}
+}
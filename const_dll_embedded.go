@@ -26,9 +26,9 @@ const (
 )
 
 const (
-	ShutdownRecv Shutdown = 0
-	ShutdownSend Shutdown = 1
-	ShutdownBoth Shutdown = 2
+	ShutdownRecv Shutdown = 1
+	ShutdownSend Shutdown = 2
+	ShutdownBoth Shutdown = 3
 )
 
 const (
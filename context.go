@@ -0,0 +1,163 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// cancelEvent is a manual-reset event which is set when a context is done, so
+// that a receive waits for its packet and the context at once
+type cancelEvent struct {
+	event windows.Handle
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newCancelEvent returns the cancelEvent of ctx, and nil when ctx is never
+// done
+func newCancelEvent(ctx context.Context) (*cancelEvent, error) {
+	if ctx.Done() == nil {
+		return nil, nil
+	}
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, Error(err.(windows.Errno))
+	}
+
+	c := &cancelEvent{
+		event: event,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		select {
+		case <-ctx.Done():
+			windows.SetEvent(c.event)
+		case <-c.stop:
+		}
+	}()
+	return c, nil
+}
+
+// Close stops watching the context and closes the event
+func (c *cancelEvent) Close() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	windows.CloseHandle(c.event)
+}
+
+// ioControlCancel is ioControlEx which cancels the operation when cancel is
+// set, and returns ERROR_OPERATION_ABORTED then. The operation is finished
+// before it returns, as its buffers go out of scope.
+func ioControlCancel(h windows.Handle, code ctlCode, ioctl unsafe.Pointer, buf *byte, bufLen uint32, overlapped *windows.Overlapped, cancel windows.Handle) (iolen uint32, err error) {
+	if !code.valid() {
+		return 0, windows.ERROR_INVALID_FUNCTION
+	}
+
+	err = windows.DeviceIoControl(h, uint32(code), (*byte)(ioctl), uint32(unsafe.Sizeof(ioCtl{})), buf, bufLen, &iolen, overlapped)
+	if err != windows.ERROR_IO_PENDING {
+		return
+	}
+
+	ev, _ := windows.WaitForMultipleObjects([]windows.Handle{overlapped.HEvent &^ 1, cancel}, false, windows.INFINITE)
+	if ev == windows.WAIT_OBJECT_0+1 {
+		windows.CancelIoEx(h, overlapped)
+	}
+
+	err = windows.GetOverlappedResult(h, overlapped, &iolen, true)
+
+	return
+}
+
+// recvCancel is Recv which stops waiting when c is set, and returns the error
+// of ctx then
+func (h *Handle) recvCancel(ctx context.Context, c *cancelEvent, buffer []byte, address *Address) (uint, error) {
+	n, err := h.recvCancelOnce(ctx, c, buffer, address)
+	for i := 0; i < reopenRetries && err != nil && err != ctx.Err() && h.reopenIfInvalid(err); i++ {
+		n, err = h.recvCancelOnce(ctx, c, buffer, address)
+	}
+	return n, err
+}
+
+func (h *Handle) recvCancelOnce(ctx context.Context, c *cancelEvent, buffer []byte, address *Address) (uint, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c == nil || h.isNonBlocking() {
+		return h.recv(buffer, address)
+	}
+
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
+	if h.flags&FlagSendOnly != 0 {
+		return 0, ErrWrongDirection
+	}
+
+	addrLen := uint32(unsafe.Sizeof(Address{}))
+	recv := recv{
+		Addr:       uint64(uintptr(unsafe.Pointer(address))),
+		AddrLenPtr: uint64(uintptr(unsafe.Pointer(&addrLen))),
+	}
+
+	iolen, err := ioControlCancel(h.handle(), ioCtlRecv, unsafe.Pointer(&recv), bufferPtr(buffer), uint32(len(buffer)), h.readOverlapped(), c.event)
+	if err != nil {
+		if err == windows.ERROR_OPERATION_ABORTED && ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return uint(iolen), Error(err.(windows.Errno))
+	}
+	h.counters.observe(*address)
+
+	return uint(iolen), nil
+}
+
+// RecvContext is Recv which returns the error of ctx when ctx is done, and
+// the pending receive is canceled with CancelIoEx rather than waiting for
+// the next packet
+func (h *Handle) RecvContext(ctx context.Context, buffer []byte, address *Address) (uint, error) {
+	c, err := newCancelEvent(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	return h.recvCancel(ctx, c, buffer, address)
+}
+
+// ForEachContext is ForEach which also stops when ctx is done, and returns
+// the error of ctx then
+func (h *Handle) ForEachContext(ctx context.Context, handler Handler) error {
+	c, err := newCancelEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buffer := make([]byte, MTUMax)
+	address := new(Address)
+
+	for {
+		n, err := h.recvCancel(ctx, c, buffer, address)
+		if err != nil {
+			if err == ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		if err := handler(buffer[:n], address); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// +build windows
+
+package divert
+
+// FilterCoverage evaluates filter on layer against samples with EvalFilter,
+// and returns how many of them match and do not match, so that a filter is
+// tuned against recorded traffic
+func FilterCoverage(filter string, layer Layer, samples []CapturedPacket) (matched, unmatched int, err error) {
+	misses, err := FilterMisses(filter, layer, samples)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(samples) - len(misses), len(misses), nil
+}
+
+// FilterMisses returns the indexes of samples which do not match filter on
+// layer. The filter is compiled once, and every sample is evaluated with its
+// address on layer.
+func FilterMisses(filter string, layer Layer, samples []CapturedPacket) ([]int, error) {
+	object, err := CompileFilter(filter, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	misses := []int(nil)
+	for i := range samples {
+		address := samples[i].Address
+		address.SetLayer(layer)
+
+		ok, err := EvalFilter(object, samples[i].Data, &address)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			misses = append(misses, i)
+		}
+	}
+	return misses, nil
+}
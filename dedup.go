@@ -0,0 +1,61 @@
+// +build windows
+
+package divert
+
+import (
+	"sync"
+	"time"
+)
+
+// Deduper drops the packets which are seen again within a window, such as a
+// packet captured by handles on both the network forward and the network
+// layers. Packets are keyed by HashPacket, and the memory is bounded by the
+// packets of two windows.
+type Deduper struct {
+	mu      sync.Mutex
+	window  time.Duration
+	rotated time.Time
+	cur     map[uint64]time.Time
+	prev    map[uint64]time.Time
+}
+
+// NewDeduper returns a Deduper which remembers a packet for window
+func NewDeduper(window time.Duration) *Deduper {
+	return &Deduper{
+		window:  window,
+		rotated: time.Now(),
+		cur:     make(map[uint64]time.Time),
+		prev:    make(map[uint64]time.Time),
+	}
+}
+
+// Seen reports whether the packet in buffer is seen within the window, and
+// remembers it otherwise. The address is not part of the key, as a packet
+// has different addresses on different layers. A packet which can not be
+// hashed is never seen.
+func (d *Deduper) Seen(buffer []byte, address *Address) bool {
+	hash, err := HashPacket(buffer, 0)
+	if err != nil {
+		return false
+	}
+
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.rotated) >= d.window {
+		d.prev, d.cur = d.cur, make(map[uint64]time.Time, len(d.cur))
+		d.rotated = now
+	}
+
+	if t, ok := d.cur[hash]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	if t, ok := d.prev[hash]; ok && now.Sub(t) < d.window {
+		return true
+	}
+
+	d.cur[hash] = now
+	return false
+}
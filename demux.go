@@ -0,0 +1,104 @@
+// +build windows
+
+package divert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Demuxer routes packets to a pool of workers, and all the packets of a
+// connection in both directions go to the same worker, so that a worker sees
+// them in order. Packets are routed by the 5-tuple rather than HashPacket,
+// which also hashes the payload, and the packets which are not TCP or UDP are
+// routed by the addresses.
+type Demuxer struct {
+	workers []chan CapturedPacket
+	once    sync.Once
+}
+
+// NewDemuxer returns a Demuxer of workers, each of which buffers up to n
+// packets
+func NewDemuxer(workers, n int) *Demuxer {
+	if workers < 1 {
+		panic("divert: number of workers is less than 1")
+	}
+
+	d := &Demuxer{workers: make([]chan CapturedPacket, workers)}
+	for i := range d.workers {
+		d.workers[i] = make(chan CapturedPacket, n)
+	}
+	return d
+}
+
+// Worker returns the channel of the i-th worker, which is closed by Close
+func (d *Demuxer) Worker(i int) <-chan CapturedPacket {
+	return d.workers[i]
+}
+
+// Route returns the index of the worker of packet, and 0 for a packet which
+// can not be parsed
+func (d *Demuxer) Route(packet []byte) int {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return 0
+	}
+	return int(flowHash(p) % uint64(len(d.workers)))
+}
+
+// Dispatch sends a copy of packet to its worker, and blocks when the worker
+// is full. It is a Handler, so that h.ForEach(d.Dispatch) runs the demuxer.
+func (d *Demuxer) Dispatch(packet []byte, address *Address) error {
+	d.workers[d.Route(packet)] <- CapturedPacket{
+		Data:    append([]byte(nil), packet...),
+		Address: *address,
+		Time:    time.Now(),
+	}
+	return nil
+}
+
+// Close closes the channels of the workers, and Dispatch must not be called
+// after it
+func (d *Demuxer) Close() {
+	d.once.Do(func() {
+		for _, w := range d.workers {
+			close(w)
+		}
+	})
+}
+
+// flowHash hashes the protocol and the endpoints of p, which are sorted
+// so that both directions of a connection have the same hash
+func flowHash(p *Packet) uint64 {
+	src, dst := []byte(nil), []byte(nil)
+	switch {
+	case p.IPv4 != nil:
+		src, dst = append(src, p.IPv4[12:16]...), append(dst, p.IPv4[16:20]...)
+	case p.IPv6 != nil:
+		src, dst = append(src, p.IPv6[8:24]...), append(dst, p.IPv6[24:40]...)
+	}
+
+	ports := [4]byte{}
+	switch {
+	case p.TCP != nil:
+		binary.BigEndian.PutUint16(ports[0:], p.TCP.SrcPort())
+		binary.BigEndian.PutUint16(ports[2:], p.TCP.DstPort())
+	case p.UDP != nil:
+		binary.BigEndian.PutUint16(ports[0:], p.UDP.SrcPort())
+		binary.BigEndian.PutUint16(ports[2:], p.UDP.DstPort())
+	}
+	src, dst = append(src, ports[0:2]...), append(dst, ports[2:4]...)
+
+	if bytes.Compare(src, dst) > 0 {
+		src, dst = dst, src
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte{byte(p.Protocol)})
+	h.Write(src)
+	h.Write(dst)
+	return h.Sum64()
+}
@@ -2,6 +2,8 @@
 
 package divert
 
+import "unsafe"
+
 type ctlCode uint32
 
 const (
@@ -53,6 +55,12 @@ func (c ctlCode) String() string {
 	}
 }
 
+// valid reports whether c is one of the IOCTLs of WinDivert, and the other
+// codes are rejected with ERROR_INVALID_FUNCTION before DeviceIoControl
+func (c ctlCode) valid() bool {
+	return c.String() != ""
+}
+
 type ioCtl struct {
 	b1, b2, b3, b4 uint32
 }
@@ -95,3 +103,30 @@ type setParam struct {
 	Param uint32
 	_     uint32
 }
+
+// The structs above must have the size of WINDIVERT_IOCTL in
+// windivert_device.h, which is packed and uses UINT64 for pointers on both
+// 32-bit and 64-bit Windows, so the same layouts are used for all GOARCH and
+// no layout is selected at run time. The driver of 32-bit Windows is
+// WinDivert32.sys and the driver of 64-bit Windows is WinDivert64.sys, and a
+// 32-bit process on 64-bit Windows is rejected by Open unless SetAllowWow64 is
+// called. The declarations below fail to compile when a size is not 16 bytes,
+// which is checked for GOARCH=386 and GOARCH=amd64.
+var (
+	_ [unsafe.Sizeof(ioCtl{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(ioCtl{})]struct{}
+	_ [unsafe.Sizeof(recv{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(recv{})]struct{}
+	_ [unsafe.Sizeof(send{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(send{})]struct{}
+	_ [unsafe.Sizeof(initialize{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(initialize{})]struct{}
+	_ [unsafe.Sizeof(startup{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(startup{})]struct{}
+	_ [unsafe.Sizeof(shutdown{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(shutdown{})]struct{}
+	_ [unsafe.Sizeof(getParam{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(getParam{})]struct{}
+	_ [unsafe.Sizeof(setParam{}) - 16]struct{}
+	_ [16 - unsafe.Sizeof(setParam{})]struct{}
+)
@@ -0,0 +1,89 @@
+// +build windows
+
+package divert
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// flagNames returns the names of flags joined by |
+func flagNames(flags uint64) string {
+	names := []string(nil)
+	for _, f := range []struct {
+		flag uint64
+		name string
+	}{
+		{FlagSniff, "Sniff"},
+		{FlagDrop, "Drop"},
+		{FlagRecvOnly, "RecvOnly"},
+		{FlagSendOnly, "SendOnly"},
+		{FlagNoInstall, "NoInstall"},
+		{FlagFragments, "Fragments"},
+	} {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("0x%x", flags))
+	}
+	if len(names) == 0 {
+		return "Default"
+	}
+	return strings.Join(names, "|")
+}
+
+// Diagnostics returns a report of the handle and the driver, with the
+// arguments of Open, the parameters, the version of the driver and the
+// statistics of the queue, for pasting into a bug report. A value which can
+// not be got is reported with its error, and MaxDelay is reset as by
+// QueueStats.
+func (h *Handle) Diagnostics() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "filter:   %q\n", h.filter)
+	fmt.Fprintf(b, "layer:    %v\n", h.layer)
+	fmt.Fprintf(b, "priority: %v\n", h.priority)
+	fmt.Fprintf(b, "flags:    %v\n", flagNames(h.flags))
+
+	if h.handle() == windows.InvalidHandle {
+		fmt.Fprintf(b, "handle:   closed\n")
+		return b.String()
+	}
+	fmt.Fprintf(b, "handle:   %v, non-blocking: %v, auto reopen: %v\n", h.handle(), h.isNonBlocking(), h.autoReopen)
+
+	major, err := h.GetParam(VersionMajor)
+	if err == nil {
+		minor, er := h.GetParam(VersionMinor)
+		err = er
+		fmt.Fprintf(b, "version:  %v.%v\n", major, minor)
+	}
+	if err != nil {
+		fmt.Fprintf(b, "version:  %v\n", err)
+	}
+
+	for _, p := range []Param{QueueLength, QueueTime, QueueSize} {
+		if v, err := h.GetParam(p); err != nil {
+			fmt.Fprintf(b, "%v: %v\n", p, err)
+		} else {
+			fmt.Fprintf(b, "%v: %v\n", p, v)
+		}
+	}
+
+	if stats, err := h.QueueStats(); err != nil {
+		fmt.Fprintf(b, "queue:    %v\n", err)
+	} else {
+		fmt.Fprintf(b, "queue:    received %v, max delay %v\n", stats.Received, stats.MaxDelay)
+	}
+	if n, err := h.QueueOccupancy(); err != nil {
+		fmt.Fprintf(b, "occupancy: %v\n", err)
+	} else {
+		fmt.Fprintf(b, "occupancy: about %v packets\n", n)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,213 @@
+// +build windows
+
+package divert
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+)
+
+// FieldDiff is a field which differs between two packets, named as in the
+// WinDivert filter language, such as ip.TTL or tcp.Payload[4:8] for a range
+// of payload bytes. A field which one packet does not have is empty.
+type FieldDiff struct {
+	Field  string
+	Before string
+	After  string
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%v: %v -> %v", d.Field, d.Before, d.After)
+}
+
+// DiffPackets parses before and after, and returns the header fields which
+// differ and the ranges of payload bytes which differ, for debugging a
+// packet rewriter. A packet which can not be parsed is a difference of the
+// field packet.
+func DiffPackets(before, after []byte) []FieldDiff {
+	p, err := ParsePacket(before)
+	if err != nil {
+		return []FieldDiff{{Field: "packet", Before: err.Error(), After: ""}}
+	}
+	q, err := ParsePacket(after)
+	if err != nil {
+		return []FieldDiff{{Field: "packet", Before: "", After: err.Error()}}
+	}
+
+	diffs := []FieldDiff(nil)
+
+	a, b := packetFields(p), packetFields(q)
+	for _, name := range fieldOrder(a, b) {
+		if va, vb := lookupField(a, name), lookupField(b, name); va != vb {
+			diffs = append(diffs, FieldDiff{Field: name, Before: va, After: vb})
+		}
+	}
+
+	prefix := "payload"
+	switch {
+	case p.TCP != nil && q.TCP != nil:
+		prefix = "tcp.Payload"
+	case p.UDP != nil && q.UDP != nil:
+		prefix = "udp.Payload"
+	}
+	return append(diffs, payloadDiffs(prefix, p.Payload, q.Payload)...)
+}
+
+type namedField struct {
+	name  string
+	value string
+}
+
+func lookupField(fields []namedField, name string) string {
+	for _, f := range fields {
+		if f.name == name {
+			return f.value
+		}
+	}
+	return ""
+}
+
+// fieldOrder returns the names of a followed by the names of b which are not
+// in a
+func fieldOrder(a, b []namedField) []string {
+	names := make([]string, 0, len(a)+len(b))
+	seen := make(map[string]bool)
+	for _, fields := range [][]namedField{a, b} {
+		for _, f := range fields {
+			if !seen[f.name] {
+				seen[f.name] = true
+				names = append(names, f.name)
+			}
+		}
+	}
+	return names
+}
+
+func packetFields(p *Packet) []namedField {
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	x := func(v uint64) string { return "0x" + strconv.FormatUint(v, 16) }
+	b := func(v bool) string {
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+
+	fields := []namedField(nil)
+	switch {
+	case p.IPv4 != nil:
+		ip := p.IPv4
+		fields = append(fields,
+			namedField{"ip.HdrLength", u(uint64(ip.HdrLength() / 4))},
+			namedField{"ip.TOS", u(uint64(ip[1]))},
+			namedField{"ip.Length", u(uint64(ip.Length()))},
+			namedField{"ip.Id", x(uint64(ip.ID()))},
+			namedField{"ip.DF", b(ip.DF())},
+			namedField{"ip.MF", b(ip.MF())},
+			namedField{"ip.FragOff", u(uint64(ip.FragOff()))},
+			namedField{"ip.TTL", u(uint64(ip.TTL()))},
+			namedField{"ip.Protocol", ip.Protocol().String()},
+			namedField{"ip.Checksum", x(uint64(ip.Checksum()))},
+			namedField{"ip.SrcAddr", netip.AddrFrom4(ip.SrcAddr()).String()},
+			namedField{"ip.DstAddr", netip.AddrFrom4(ip.DstAddr()).String()},
+		)
+	case p.IPv6 != nil:
+		ip := p.IPv6
+		fields = append(fields,
+			namedField{"ipv6.TrafficClass", u(uint64(ip[0]&0x0f<<4 | ip[1]>>4))},
+			namedField{"ipv6.FlowLabel", x(uint64(ip[1]&0x0f)<<16 | uint64(ip[2])<<8 | uint64(ip[3]))},
+			namedField{"ipv6.Length", u(uint64(ip.PayloadLength()))},
+			namedField{"ipv6.NextHdr", ip.NextHdr().String()},
+			namedField{"ipv6.HopLimit", u(uint64(ip.HopLimit()))},
+			namedField{"ipv6.SrcAddr", netip.AddrFrom16(ip.SrcAddr()).String()},
+			namedField{"ipv6.DstAddr", netip.AddrFrom16(ip.DstAddr()).String()},
+		)
+	}
+
+	switch {
+	case p.ICMP != nil:
+		fields = append(fields,
+			namedField{"icmp.Type", p.ICMP.Type().String()},
+			namedField{"icmp.Code", u(uint64(p.ICMP.Code()))},
+			namedField{"icmp.Checksum", x(uint64(p.ICMP.Checksum()))},
+			namedField{"icmp.Body", x(uint64(p.ICMP.Body()))},
+		)
+	case p.ICMPv6 != nil:
+		fields = append(fields,
+			namedField{"icmpv6.Type", p.ICMPv6.Type().String()},
+			namedField{"icmpv6.Code", u(uint64(p.ICMPv6.Code()))},
+			namedField{"icmpv6.Checksum", x(uint64(p.ICMPv6.Checksum()))},
+			namedField{"icmpv6.Body", x(uint64(p.ICMPv6.Body()))},
+		)
+	case p.TCP != nil:
+		tcp := p.TCP
+		fields = append(fields,
+			namedField{"tcp.SrcPort", u(uint64(tcp.SrcPort()))},
+			namedField{"tcp.DstPort", u(uint64(tcp.DstPort()))},
+			namedField{"tcp.SeqNum", u(uint64(tcp.SeqNum()))},
+			namedField{"tcp.AckNum", u(uint64(tcp.AckNum()))},
+			namedField{"tcp.HdrLength", u(uint64(tcp.HdrLength() / 4))},
+			namedField{"tcp.Urg", b(tcp.Urg())},
+			namedField{"tcp.Ack", b(tcp.Ack())},
+			namedField{"tcp.Psh", b(tcp.Psh())},
+			namedField{"tcp.Rst", b(tcp.Rst())},
+			namedField{"tcp.Syn", b(tcp.Syn())},
+			namedField{"tcp.Fin", b(tcp.Fin())},
+			namedField{"tcp.Window", u(uint64(tcp.Window()))},
+			namedField{"tcp.Checksum", x(uint64(tcp.Checksum()))},
+			namedField{"tcp.UrgPtr", u(uint64(tcp.UrgPtr()))},
+			namedField{"tcp.PayloadLength", u(uint64(len(p.Payload)))},
+		)
+	case p.UDP != nil:
+		udp := p.UDP
+		fields = append(fields,
+			namedField{"udp.SrcPort", u(uint64(udp.SrcPort()))},
+			namedField{"udp.DstPort", u(uint64(udp.DstPort()))},
+			namedField{"udp.Length", u(uint64(udp.Length()))},
+			namedField{"udp.Checksum", x(uint64(udp.Checksum()))},
+			namedField{"udp.PayloadLength", u(uint64(len(p.Payload)))},
+		)
+	}
+	return fields
+}
+
+// payloadDiffs returns the ranges of bytes which differ between a and b, and
+// the bytes beyond the shorter one are a range of their own
+func payloadDiffs(prefix string, a, b []byte) []FieldDiff {
+	diffs := []FieldDiff(nil)
+
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; {
+		if a[i] == b[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < n && a[j] != b[j] {
+			j++
+		}
+		diffs = append(diffs, FieldDiff{
+			Field:  fmt.Sprintf("%v[%v:%v]", prefix, i, j),
+			Before: fmt.Sprintf("%x", a[i:j]),
+			After:  fmt.Sprintf("%x", b[i:j]),
+		})
+		i = j
+	}
+
+	if len(a) != len(b) {
+		m := len(a)
+		if len(b) > m {
+			m = len(b)
+		}
+		diffs = append(diffs, FieldDiff{
+			Field:  fmt.Sprintf("%v[%v:%v]", prefix, n, m),
+			Before: fmt.Sprintf("%x", a[n:]),
+			After:  fmt.Sprintf("%x", b[n:]),
+		})
+	}
+	return diffs
+}
@@ -3,16 +3,84 @@
 package divert
 
 import (
+	"errors"
 	"fmt"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
 
-var once = sync.Once{}
+// initGuard runs an initialization until it succeeds. Unlike sync.Once, an
+// attempt which fails, such as before the driver is ready, is made again by
+// the next call.
+type initGuard struct {
+	mu   sync.Mutex
+	done bool
+}
+
+// Do runs f unless a call of f has succeeded, and returns its error
+func (g *initGuard) Do(f func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.done {
+		return nil
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	g.done = true
+	return nil
+}
+
+// openInit loads WinDivert.dll and checks the version for the first Open
+var openInit = initGuard{}
+
+var dllPath = ""
+
+// SetDLLPath sets the path of WinDivert.dll which is loaded by the first call
+// of Open; when it is not set, the default DLL search order is used. It must
+// be called before Open and is ignored by the divert_cgo and divert_embedded
+// builds.
+func SetDLLPath(path string) {
+	dllPath = path
+}
+
+var allowWow64 = false
+
+// SetAllowWow64 sets whether Open is allowed in a 32-bit process running under
+// WOW64 on 64-bit Windows, which is rejected by default. It must be called
+// before Open.
+func SetAllowWow64(allow bool) {
+	allowWow64 = allow
+}
+
+var allowUnknownVersion = false
+
+// SetAllowUnknownVersion sets whether Open goes on with a driver whose version
+// is not known to be supported, such as a newer release, after logging a
+// warning. It is rejected by default. It must be called before Open.
+func SetAllowUnknownVersion(allow bool) {
+	allowUnknownVersion = allow
+}
+
+// filterObjectMagic starts a filter object compiled by CompileFilter
+const filterObjectMagic = "@WinDiv_"
+
+// OpenCompiled is Open with a filter object returned by CompileFilter, which
+// the driver takes without parsing the filter again
+func OpenCompiled(compiled []byte, layer Layer, priority int16, flags uint64) (*Handle, error) {
+	if !strings.HasPrefix(string(compiled), filterObjectMagic) {
+		return nil, errFilterObject
+	}
+	return Open(string(compiled), layer, priority, flags)
+}
 
 func GetVersionInfo() (ver string, err error) {
 	h, err := Open("false", LayerNetwork, PriorityDefault, FlagDefault)
@@ -23,39 +91,118 @@ func GetVersionInfo() (ver string, err error) {
 		err = h.Close()
 	}()
 
+	return h.version()
+}
+
+func (h *Handle) version() (string, error) {
 	major, err := h.GetParam(VersionMajor)
 	if err != nil {
-		return
+		return "", err
 	}
 
 	minor, err := h.GetParam(VersionMinor)
 	if err != nil {
-		return
+		return "", err
 	}
 
-	ver = strings.Join([]string{strconv.Itoa(int(major)), strconv.Itoa(int(minor))}, ".")
-	return
+	return strings.Join([]string{strconv.Itoa(int(major)), strconv.Itoa(int(minor))}, "."), nil
+}
+
+// checkVersion verifies the version of the driver is supported and that it
+// matches dllVer, the version of WinDivert.dll. An empty dllVer skips the
+// second check when the version of WinDivert.dll is unknown.
+func checkVersion(dllVer string) error {
+	vers := map[string]struct{}{
+		"2.0": {},
+		"2.1": {},
+		"2.2": {},
+	}
+	ver, err := func() (ver string, err error) {
+		h, err := open("false", LayerNetwork, PriorityDefault, FlagDefault)
+		if err != nil {
+			return
+		}
+		defer func() {
+			if er := h.Close(); err == nil {
+				err = er
+			}
+		}()
+
+		return h.version()
+	}()
+	if err != nil {
+		return err
+	}
+	if _, ok := vers[ver]; !ok {
+		if !allowUnknownVersion {
+			return fmt.Errorf("unsupported windivert version: %v", ver)
+		}
+		logf("divert: windivert version %v is not known to be supported", ver)
+	}
+	if dllVer != "" && dllVer != ver {
+		return fmt.Errorf("%w: WinDivert.dll is %v, driver is %v", ErrVersionMismatch, dllVer, ver)
+	}
+	return nil
 }
 
 func checkForWow64() error {
+	if allowWow64 {
+		return nil
+	}
+
 	var b bool
 	err := windows.IsWow64Process(windows.CurrentProcess(), &b)
 	if err != nil {
 		return fmt.Errorf("Unable to determine whether the process is running under WOW64: %v", err)
 	}
 	if b {
-		return fmt.Errorf("You must use the 64-bit version of WireGuard on this computer.")
+		return errors.New("The process is running under WOW64, use a 64-bit build of the program or call SetAllowWow64 before Open")
 	}
 	return nil
 }
 
 func ioControlEx(h windows.Handle, code ctlCode, ioctl unsafe.Pointer, buf *byte, bufLen uint32, overlapped *windows.Overlapped) (iolen uint32, err error) {
+	if !code.valid() {
+		return 0, windows.ERROR_INVALID_FUNCTION
+	}
+
+	err = windows.DeviceIoControl(h, uint32(code), (*byte)(ioctl), uint32(unsafe.Sizeof(ioCtl{})), buf, bufLen, &iolen, overlapped)
+	if err != windows.ERROR_IO_PENDING {
+		return
+	}
+
+	err = windows.GetOverlappedResult(h, overlapped, &iolen, true)
+
+	return
+}
+
+// ioControlTimeout is ioControlEx which waits up to timeout, and cancels the
+// operation and returns WAIT_TIMEOUT when it is still pending then. The
+// operation is finished before it returns, as its buffers go out of scope.
+func ioControlTimeout(h windows.Handle, code ctlCode, ioctl unsafe.Pointer, buf *byte, bufLen uint32, overlapped *windows.Overlapped, timeout time.Duration) (iolen uint32, err error) {
+	if !code.valid() {
+		return 0, windows.ERROR_INVALID_FUNCTION
+	}
+
 	err = windows.DeviceIoControl(h, uint32(code), (*byte)(ioctl), uint32(unsafe.Sizeof(ioCtl{})), buf, bufLen, &iolen, overlapped)
 	if err != windows.ERROR_IO_PENDING {
 		return
 	}
 
+	ms := uint32(0)
+	if timeout > 0 {
+		ms = uint32(timeout / time.Millisecond)
+	}
+
+	timedOut := false
+	if ev, _ := windows.WaitForSingleObject(overlapped.HEvent&^1, ms); ev == uint32(windows.WAIT_TIMEOUT) {
+		timedOut = windows.CancelIoEx(h, overlapped) == nil
+	}
+
 	err = windows.GetOverlappedResult(h, overlapped, &iolen, true)
+	if timedOut && err == windows.ERROR_OPERATION_ABORTED {
+		err = windows.WAIT_TIMEOUT
+	}
 
 	return
 }
@@ -63,8 +210,9 @@ func ioControlEx(h windows.Handle, code ctlCode, ioctl unsafe.Pointer, buf *byte
 func ioControl(h windows.Handle, code ctlCode, ioctl unsafe.Pointer, buf *byte, bufLen uint32) (iolen uint32, err error) {
 	event, _ := windows.CreateEvent(nil, 0, 0, nil)
 
+	// the low-order bit keeps the completion out of a completion port
 	overlapped := windows.Overlapped{
-		HEvent: event,
+		HEvent: event | 1,
 	}
 
 	iolen, err = ioControlEx(h, code, ioctl, buf, bufLen, &overlapped)
@@ -78,72 +226,378 @@ type Handle struct {
 	windows.Handle
 	rOverlapped windows.Overlapped
 	wOverlapped windows.Overlapped
+	rOnce       sync.Once
+	wOnce       sync.Once
+
+	// pending is the receive started by RecvStart
+	pending *Overlapped
+
+	counters queueCounters
+
+	paramMu sync.Mutex
+	params  map[Param]uint64
+	set     map[Param]uint64
+
+	// the arguments of Open, which are used again by Reopen
+	filter   string
+	layer    Layer
+	priority int16
+	flags    uint64
+
+	autoReopen bool
+
+	// nonBlocking is set atomically, as it may be changed while receives
+	// are in flight on other goroutines
+	nonBlocking uint32
+
+	sendGate sendGate
+}
+
+// handle returns the handle of the driver, which is InvalidHandle after Close.
+// It is read and swapped atomically, as Close may be called while other
+// goroutines are in Recv or Send.
+func (h *Handle) handle() windows.Handle {
+	return windows.Handle(atomic.LoadUintptr((*uintptr)(unsafe.Pointer(&h.Handle))))
+}
+
+// swapHandle replaces the handle of the driver with hd, and returns the old
+// one
+func (h *Handle) swapHandle(hd windows.Handle) windows.Handle {
+	return windows.Handle(atomic.SwapUintptr((*uintptr)(unsafe.Pointer(&h.Handle)), uintptr(hd)))
+}
+
+// readOverlapped returns the overlapped of Recv, RecvEx and RecvTimeout, and
+// writeOverlapped returns the overlapped of Send and SendEx. Their events are
+// created on the first use, so that a handle used in one direction has one
+// event.
+func (h *Handle) readOverlapped() *windows.Overlapped {
+	h.rOnce.Do(func() {
+		event, _ := windows.CreateEvent(nil, 0, 0, nil)
+		orEvent(&h.rOverlapped.HEvent, event)
+	})
+	return &h.rOverlapped
+}
+
+func (h *Handle) writeOverlapped() *windows.Overlapped {
+	h.wOnce.Do(func() {
+		event, _ := windows.CreateEvent(nil, 0, 0, nil)
+		orEvent(&h.wOverlapped.HEvent, event)
+	})
+	return &h.wOverlapped
 }
 
 func (h *Handle) Recv(buffer []byte, address *Address) (uint, error) {
-	addrLen := uint(unsafe.Sizeof(Address{}))
+	n, err := h.recv(buffer, address)
+	for i := 0; i < reopenRetries && err != nil && h.reopenIfInvalid(err); i++ {
+		n, err = h.recv(buffer, address)
+	}
+	return n, err
+}
+
+func (h *Handle) recv(buffer []byte, address *Address) (uint, error) {
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
+	if h.flags&FlagSendOnly != 0 {
+		return 0, ErrWrongDirection
+	}
+
+	// the driver writes a UINT to AddrLenPtr
+	addrLen := uint32(unsafe.Sizeof(Address{}))
+	recv := recv{
+		Addr:       uint64(uintptr(unsafe.Pointer(address))),
+		AddrLenPtr: uint64(uintptr(unsafe.Pointer(&addrLen))),
+	}
+
+	iolen, err := h.recvIoControl(unsafe.Pointer(&recv), buffer, h.readOverlapped())
+	if err != nil {
+		return uint(iolen), Error(err.(windows.Errno))
+	}
+	h.counters.observe(*address)
+
+	return uint(iolen), nil
+}
+
+// bufferPtr returns the first byte of buffer, and nil for an empty buffer,
+// which is a receive of the address only
+func bufferPtr(buffer []byte) *byte {
+	if len(buffer) == 0 {
+		return nil
+	}
+	return &buffer[0]
+}
+
+// RecvAddress receives the address of an event of the flow, socket or reflect
+// layer, which have no packet data, without a buffer
+func (h *Handle) RecvAddress(address *Address) error {
+	_, err := h.Recv(nil, address)
+	return err
+}
+
+// recvIoControl starts a receive and waits for it, and returns WSAEWOULDBLOCK
+// at once when the handle is non-blocking and no packet is queued
+func (h *Handle) recvIoControl(ioctl unsafe.Pointer, buffer []byte, overlapped *windows.Overlapped) (uint32, error) {
+	if !h.isNonBlocking() {
+		return ioControlEx(h.handle(), ioCtlRecv, ioctl, bufferPtr(buffer), uint32(len(buffer)), overlapped)
+	}
+
+	iolen, err := ioControlTimeout(h.handle(), ioCtlRecv, ioctl, bufferPtr(buffer), uint32(len(buffer)), overlapped, 0)
+	if err == windows.WAIT_TIMEOUT {
+		err = windows.WSAEWOULDBLOCK
+	}
+	return iolen, err
+}
+
+// SetNonBlocking sets whether Recv, RecvEx and RecvExOverlapped return
+// ErrWouldBlock at once when no packet is queued, rather than waiting for a
+// packet. A handle is blocking by default, and it may be set while receives
+// are in flight, which wait as they have started.
+func (h *Handle) SetNonBlocking(b bool) {
+	v := uint32(0)
+	if b {
+		v = 1
+	}
+	atomic.StoreUint32(&h.nonBlocking, v)
+}
+
+func (h *Handle) isNonBlocking() bool {
+	return atomic.LoadUint32(&h.nonBlocking) == 1
+}
+
+// RecvTimeout is Recv which waits up to timeout for a packet, and returns
+// ErrTimeout when no packet is received in time. It shares the overlapped of
+// the handle with Recv, and they must not be called at the same time.
+func (h *Handle) RecvTimeout(buffer []byte, address *Address, timeout time.Duration) (uint, error) {
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
+	if h.flags&FlagSendOnly != 0 {
+		return 0, ErrWrongDirection
+	}
+
+	addrLen := uint32(unsafe.Sizeof(Address{}))
 	recv := recv{
 		Addr:       uint64(uintptr(unsafe.Pointer(address))),
 		AddrLenPtr: uint64(uintptr(unsafe.Pointer(&addrLen))),
 	}
 
-	iolen, err := ioControlEx(h.Handle, ioCtlRecv, unsafe.Pointer(&recv), &buffer[0], uint32(len(buffer)), &h.rOverlapped)
+	iolen, err := ioControlTimeout(h.handle(), ioCtlRecv, unsafe.Pointer(&recv), bufferPtr(buffer), uint32(len(buffer)), h.readOverlapped(), timeout)
 	if err != nil {
 		return uint(iolen), Error(err.(windows.Errno))
 	}
+	h.counters.observe(*address)
 
 	return uint(iolen), nil
 }
 
+// bufferPool keeps buffers of MTUMax bytes for receiving packets
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, MTUMax)
+		return &b
+	},
+}
+
+// RecvPacket receives a packet into a buffer from an internal pool, and
+// returns a copy of the packet of exactly the received length with a new
+// address, both of which are owned by the caller
+func (h *Handle) RecvPacket() ([]byte, *Address, error) {
+	bp := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bp)
+
+	address := new(Address)
+	n, err := h.Recv(*bp, address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append([]byte(nil), (*bp)[:n]...), address, nil
+}
+
+// RecvEx receives up to len(address) packets, which is at most BatchMax, the
+// WINDIVERT_BATCH_MAX of the driver
 func (h *Handle) RecvEx(buffer []byte, address []Address) (uint, uint, error) {
-	addrLen := uint(len(address)) * uint(unsafe.Sizeof(Address{}))
+	n, m, err := h.RecvExOverlapped(buffer, address, h.readOverlapped())
+	for i := 0; i < reopenRetries && err != nil && h.reopenIfInvalid(err); i++ {
+		n, m, err = h.RecvExOverlapped(buffer, address, h.readOverlapped())
+	}
+	return n, m, err
+}
+
+// RecvExOverlapped is RecvEx with an overlapped owned by the caller, which
+// must have an event. RecvEx shares one overlapped of the handle, and
+// goroutines with their own overlapped can receive at the same time.
+func (h *Handle) RecvExOverlapped(buffer []byte, address []Address, overlapped *windows.Overlapped) (uint, uint, error) {
+	if h.handle() == windows.InvalidHandle {
+		return 0, 0, ErrClosed
+	}
+
+	if h.flags&FlagSendOnly != 0 {
+		return 0, 0, ErrWrongDirection
+	}
+
+	if len(address) < 1 || len(address) > BatchMax {
+		return 0, 0, errBatchSize
+	}
+
+	addrLen := uint32(len(address)) * uint32(unsafe.Sizeof(Address{}))
 	recv := recv{
 		Addr:       uint64(uintptr(unsafe.Pointer(&address[0]))),
 		AddrLenPtr: uint64(uintptr(unsafe.Pointer(&addrLen))),
 	}
 
-	iolen, err := ioControlEx(h.Handle, ioCtlRecv, unsafe.Pointer(&recv), &buffer[0], uint32(len(buffer)), &h.rOverlapped)
+	iolen, err := h.recvIoControl(unsafe.Pointer(&recv), buffer, overlapped)
 	if err != nil {
-		return uint(iolen), addrLen / uint(unsafe.Sizeof(Address{})), Error(err.(windows.Errno))
+		return uint(iolen), uint(addrLen) / uint(unsafe.Sizeof(Address{})), Error(err.(windows.Errno))
 	}
 
-	return uint(iolen), addrLen / uint(unsafe.Sizeof(Address{})), nil
+	n := uint(addrLen) / uint(unsafe.Sizeof(Address{}))
+	h.counters.observe(address[:n]...)
+
+	return uint(iolen), n, nil
 }
 
 func (h *Handle) Send(buffer []byte, address *Address) (uint, error) {
-	send := send{
-		Addr:    uint64(uintptr(unsafe.Pointer(address))),
-		AddrLen: uint64(unsafe.Sizeof(Address{})),
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
 	}
 
-	iolen, err := ioControlEx(h.Handle, ioCtlSend, unsafe.Pointer(&send), &buffer[0], uint32(len(buffer)), &h.wOverlapped)
+	if h.flags&FlagRecvOnly != 0 {
+		return 0, ErrWrongDirection
+	}
+
+	slot := h.acquireSend()
+	slot.req.Addr = uint64(uintptr(unsafe.Pointer(address)))
+	slot.req.AddrLen = uint64(unsafe.Sizeof(Address{}))
+	iolen, err := ioControlEx(h.handle(), ioCtlSend, unsafe.Pointer(&slot.req), &buffer[0], uint32(len(buffer)), slot.overlapped)
+	h.releaseSend(slot)
 	if err != nil {
 		return uint(iolen), Error(err.(windows.Errno))
 	}
+	if int(iolen) != len(buffer) {
+		return uint(iolen), ErrShortWrite
+	}
 
 	return uint(iolen), nil
 }
 
+// SendRecalc computes the checksums of a modified packet in buffer and sends
+// it, with a copy of address whose checksum flags are set as the checksums
+// are valid. Send is for a packet whose checksums are already correct.
+func (h *Handle) SendRecalc(buffer []byte, address *Address) (uint, error) {
+	p, err := ParsePacket(buffer)
+	if err != nil {
+		return 0, err
+	}
+	p.CalcChecksums(ChecksumDefault)
+
+	addr := *address
+	addr.SetIPChecksum(p.IPv4 != nil)
+	addr.SetTCPChecksum(p.TCP != nil && !p.Fragment)
+	addr.SetUDPChecksum(p.UDP != nil && !p.Fragment)
+	return h.Send(buffer, &addr)
+}
+
+// SendPacket sends a parsed packet, of which the checksums are computed once
+// here when it is dirty, with a copy of address whose checksum flags are set
+// by the checksums which are computed. The packet is not dirty afterwards,
+// and a packet which is not dirty is sent with address as it is.
+func (h *Handle) SendPacket(p *Packet, address *Address) (uint, error) {
+	if !p.Dirty() {
+		return h.Send(p.Buffer, address)
+	}
+	p.CalcChecksums(ChecksumDefault)
+	p.dirty = false
+
+	addr := *address
+	addr.SetIPChecksum(p.IPv4 != nil)
+	addr.SetTCPChecksum(p.TCP != nil && !p.Fragment)
+	addr.SetUDPChecksum(p.UDP != nil && !p.Fragment)
+	return h.Send(p.Buffer, &addr)
+}
+
+// SendOffload sends an outbound packet with a copy of address whose checksum
+// flags are cleared, so that the checksums are not computed in software and
+// are left to the network stack and the checksum offload of the interface.
+// It is only safe for packets sent out by an interface which offloads the
+// checksums, and an inbound or loopback packet, whose checksums are checked
+// by the stack, is sent by SendRecalc.
+func (h *Handle) SendOffload(buffer []byte, address *Address) (uint, error) {
+	if !address.Outbound() || address.Loopback() {
+		return h.SendRecalc(buffer, address)
+	}
+
+	addr := *address
+	addr.SetIPChecksum(false)
+	addr.SetTCPChecksum(false)
+	addr.SetUDPChecksum(false)
+	return h.Send(buffer, &addr)
+}
+
+// SendAs sends a packet in direction, with a copy of address whose Outbound
+// flag is set for direction, and address is left untouched
+func (h *Handle) SendAs(buffer []byte, address *Address, direction Direction) (uint, error) {
+	addr := *address
+	addr.SetDirection(direction)
+	return h.Send(buffer, &addr)
+}
+
+// SendEx sends len(address) packets, which is at most BatchMax, the
+// WINDIVERT_BATCH_MAX of the driver
 func (h *Handle) SendEx(buffer []byte, address []Address) (uint, error) {
-	send := send{
-		Addr:    uint64(uintptr(unsafe.Pointer(&address[0]))),
-		AddrLen: uint64(unsafe.Sizeof(Address{})) * uint64(len(address)),
+	slot := h.acquireSend()
+	defer h.releaseSend(slot)
+
+	return h.sendEx(buffer, address, slot.overlapped, &slot.req)
+}
+
+// SendExOverlapped is SendEx with an overlapped owned by the caller, which
+// must have an event, as RecvExOverlapped is for RecvEx
+func (h *Handle) SendExOverlapped(buffer []byte, address []Address, overlapped *windows.Overlapped) (uint, error) {
+	return h.sendEx(buffer, address, overlapped, &send{})
+}
+
+// sendEx sends with overlapped and the request req, which is set up for the
+// send
+func (h *Handle) sendEx(buffer []byte, address []Address, overlapped *windows.Overlapped, req *send) (uint, error) {
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
+	if h.flags&FlagRecvOnly != 0 {
+		return 0, ErrWrongDirection
+	}
+
+	if len(address) < 1 || len(address) > BatchMax {
+		return 0, errBatchSize
 	}
 
-	iolen, err := ioControlEx(h.Handle, ioCtlSend, unsafe.Pointer(&send), &buffer[0], uint32(len(buffer)), &h.wOverlapped)
+	req.Addr = uint64(uintptr(unsafe.Pointer(&address[0])))
+	req.AddrLen = uint64(unsafe.Sizeof(Address{})) * uint64(len(address))
+
+	iolen, err := ioControlEx(h.handle(), ioCtlSend, unsafe.Pointer(req), &buffer[0], uint32(len(buffer)), overlapped)
 	if err != nil {
 		return uint(iolen), Error(err.(windows.Errno))
 	}
+	if int(iolen) != len(buffer) {
+		return uint(iolen), ErrShortWrite
+	}
 
 	return uint(iolen), nil
 }
 
 func (h *Handle) Shutdown(how Shutdown) error {
+	if h.handle() == windows.InvalidHandle {
+		return ErrClosed
+	}
+
 	shutdown := shutdown{
 		How: uint32(how),
 	}
 
-	_, err := ioControl(h.Handle, ioCtlShutdown, unsafe.Pointer(&shutdown), nil, 0)
+	_, err := ioControl(h.handle(), ioCtlShutdown, unsafe.Pointer(&shutdown), nil, 0)
 	if err != nil {
 		return Error(err.(windows.Errno))
 	}
@@ -151,25 +605,79 @@ func (h *Handle) Shutdown(how Shutdown) error {
 	return nil
 }
 
+// closeCancelTimeout is how long Close waits for the canceled operations
+const closeCancelTimeout = time.Second
+
 func (h *Handle) Close() error {
-	windows.CloseHandle(h.rOverlapped.HEvent)
-	windows.CloseHandle(h.wOverlapped.HEvent)
+	// the handle is swapped out first, so that a concurrent Close returns
+	// ErrClosed, and a Recv or Send starting now fails with ErrClosed rather
+	// than going to the driver with a stale handle
+	hd := h.swapHandle(windows.InvalidHandle)
+	if hd == windows.InvalidHandle {
+		return ErrClosed
+	}
 
-	err := windows.CloseHandle(h.Handle)
-	if err != nil {
-		return Error(err.(windows.Errno))
+	// the operations pending on the overlapped of the handle are canceled and
+	// waited for, so that the driver does not complete them after the events
+	// are closed. They are polled rather than waited on their events, which
+	// are auto-reset and may be waited by the goroutines of the operations.
+	if windows.CancelIoEx(hd, nil) == nil {
+		deadline := time.Now().Add(closeCancelTimeout)
+		for _, o := range append([]*windows.Overlapped{&h.rOverlapped, &h.wOverlapped}, h.sendGate.extra...) {
+			iolen := uint32(0)
+			for windows.GetOverlappedResult(hd, o, &iolen, false) == windows.ERROR_IO_INCOMPLETE && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+		}
 	}
 
-	return nil
+	errs := closeErrors(nil)
+	events := []*windows.Handle{&h.rOverlapped.HEvent, &h.wOverlapped.HEvent}
+	for _, o := range h.sendGate.extra {
+		events = append(events, &o.HEvent)
+	}
+	for _, event := range events {
+		if *event&^1 == 0 {
+			continue
+		}
+		if err := windows.CloseHandle(*event &^ 1); err != nil {
+			errs = append(errs, Error(err.(windows.Errno)))
+		}
+		*event = 0
+	}
+
+	if err := windows.CloseHandle(hd); err != nil {
+		errs = append(errs, Error(err.(windows.Errno)))
+	}
+	runtime.SetFinalizer(h, nil)
+
+	return errs.err()
+}
+
+// setFinalizer closes the handle and its events when h is collected without
+// Close, as a safety net against running out of kernel handles
+func setFinalizer(h *Handle) *Handle {
+	runtime.SetFinalizer(h, func(h *Handle) {
+		if h.handle() == windows.InvalidHandle {
+			return
+		}
+		logf("divert: handle %v with filter %q is collected without Close", h.handle(), h.filter)
+		h.Close()
+	})
+	return h
 }
 
 func (h *Handle) GetParam(p Param) (uint64, error) {
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
 	getParam := getParam{
 		Param: uint32(p),
 		Value: 0,
 	}
 
-	_, err := ioControl(h.Handle, ioCtlGetParam, unsafe.Pointer(&getParam), (*byte)(unsafe.Pointer(&getParam.Value)), uint32(unsafe.Sizeof(getParam.Value)))
+	_, err := ioControl(h.handle(), ioCtlGetParam, unsafe.Pointer(&getParam), (*byte)(unsafe.Pointer(&getParam.Value)), uint32(unsafe.Sizeof(getParam.Value)))
 	if err != nil {
 		return getParam.Value, Error(err.(windows.Errno))
 	}
@@ -177,7 +685,41 @@ func (h *Handle) GetParam(p Param) (uint64, error) {
 	return getParam.Value, nil
 }
 
+// GetParamCached returns the value of p cached by a previous call, or calls
+// GetParam and caches the value. The cached value of p is invalidated by
+// SetParam, and all the cached values by InvalidateParams.
+func (h *Handle) GetParamCached(p Param) (uint64, error) {
+	h.paramMu.Lock()
+	defer h.paramMu.Unlock()
+
+	if v, ok := h.params[p]; ok {
+		return v, nil
+	}
+
+	v, err := h.GetParam(p)
+	if err != nil {
+		return v, err
+	}
+
+	if h.params == nil {
+		h.params = make(map[Param]uint64)
+	}
+	h.params[p] = v
+	return v, nil
+}
+
+// InvalidateParams drops all the values cached by GetParamCached
+func (h *Handle) InvalidateParams() {
+	h.paramMu.Lock()
+	h.params = nil
+	h.paramMu.Unlock()
+}
+
 func (h *Handle) SetParam(p Param, v uint64) error {
+	if h.handle() == windows.InvalidHandle {
+		return ErrClosed
+	}
+
 	switch p {
 	case QueueLength:
 		if v < QueueLengthMin || v > QueueLengthMax {
@@ -200,10 +742,34 @@ func (h *Handle) SetParam(p Param, v uint64) error {
 		Param: uint32(p),
 	}
 
-	_, err := ioControl(h.Handle, ioCtlSetParam, unsafe.Pointer(&setParam), nil, 0)
+	_, err := ioControl(h.handle(), ioCtlSetParam, unsafe.Pointer(&setParam), nil, 0)
 	if err != nil {
 		return Error(err.(windows.Errno))
 	}
 
+	h.paramMu.Lock()
+	delete(h.params, p)
+	if h.set == nil {
+		h.set = make(map[Param]uint64)
+	}
+	h.set[p] = v
+	h.paramMu.Unlock()
+
 	return nil
 }
+
+// SetQueueTime sets QueueTime, the longest time a packet is queued before
+// the driver drops it, which is rounded down to milliseconds and is between
+// QueueTimeMin and QueueTimeMax milliseconds
+func (h *Handle) SetQueueTime(d time.Duration) error {
+	return h.SetParam(QueueTime, uint64(d/time.Millisecond))
+}
+
+// GetQueueTime returns QueueTime as a duration
+func (h *Handle) GetQueueTime() (time.Duration, error) {
+	v, err := h.GetParam(QueueTime)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(v) * time.Millisecond, nil
+}
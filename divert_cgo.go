@@ -10,60 +10,33 @@ import "C"
 import (
 	"fmt"
 	"runtime"
-	"strconv"
-	"strings"
 	"sync"
+	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
 
-func Open(filter string, layer Layer, priority int16, flags uint64) (h *Handle, err error) {
-	once.Do(func() {
-		if er := checkForWow64(); er != nil {
-			err = er
-			return
+func Open(filter string, layer Layer, priority int16, flags uint64, opts ...Option) (h *Handle, err error) {
+	err = openInit.Do(func() error {
+		if err := checkForWow64(); err != nil {
+			return err
 		}
 
-		vers := map[string]struct{}{
-			"2.0": {},
-			"2.1": {},
-			"2.2": {},
-		}
-		ver, er := func() (ver string, err error) {
-			h, err := open("false", LayerNetwork, PriorityDefault, FlagDefault)
-			if err != nil {
-				return
-			}
-			defer func() {
-				err = h.Close()
-			}()
-
-			major, err := h.GetParam(VersionMajor)
-			if err != nil {
-				return
-			}
-
-			minor, err := h.GetParam(VersionMinor)
-			if err != nil {
-				return
-			}
-
-			ver = strings.Join([]string{strconv.Itoa(int(major)), strconv.Itoa(int(minor))}, ".")
-			return
-		}()
-		if er != nil {
-			err = er
-			return
-		}
-		if _, ok := vers[ver]; !ok {
-			err = fmt.Errorf("unsupported windivert version: %v", ver)
-		}
+		return checkVersion(fmt.Sprintf("%v.%v", C.WINDIVERT_VERSION_MAJOR, C.WINDIVERT_VERSION_MINOR))
 	})
 	if err != nil {
 		return
 	}
 
-	return open(filter, layer, priority, flags)
+	if priority, err = newOptions(opts).check(filter, layer, priority); err != nil {
+		return
+	}
+
+	h, err = open(filter, layer, priority, flags)
+	if err != nil {
+		return nil, explainOpenError(err, filter, layer, flags)
+	}
+	return h, nil
 }
 
 func open(filter string, layer Layer, priority int16, flags uint64) (h *Handle, err error) {
@@ -73,23 +46,188 @@ func open(filter string, layer Layer, priority int16, flags uint64) (h *Handle,
 
 	runtime.LockOSThread()
 	hd := C.WinDivertOpen(C.CString(filter), C.WINDIVERT_LAYER(layer), C.int16_t(priority), C.uint64_t(flags))
+	errno := C.GetLastError()
 	runtime.UnlockOSThread()
 
 	if hd == C.HANDLE(C.INVALID_HANDLE_VALUE) {
-		return nil, Error(C.GetLastError())
-	}
-
-	rEvent, _ := windows.CreateEvent(nil, 0, 0, nil)
-	wEvent, _ := windows.CreateEvent(nil, 0, 0, nil)
-
-	return &Handle{
-		Mutex:  sync.Mutex{},
-		Handle: windows.Handle(hd),
-		rOverlapped: windows.Overlapped{
-			HEvent: rEvent,
-		},
-		wOverlapped: windows.Overlapped{
-			HEvent: wEvent,
-		},
-	}, nil
+		return nil, Error(errno)
+	}
+
+	return setFinalizer(&Handle{
+		Mutex:    sync.Mutex{},
+		Handle:   windows.Handle(hd),
+		filter:   filter,
+		layer:    layer,
+		priority: priority,
+		flags:    flags,
+	}), nil
+}
+
+// filterObjectLen is large enough for the object of a filter of the maximum
+// length
+const filterObjectLen = 32 * 1024
+
+// CalcChecksums computes the checksums of packet with
+// WinDivertHelperCalcChecksums, and sets the checksum flags of address when
+// it is not nil
+func CalcChecksums(packet []byte, address *Address, flags uint64) error {
+	if len(packet) == 0 {
+		return errPacketShort
+	}
+
+	if C.WinDivertHelperCalcChecksums(unsafe.Pointer(&packet[0]), C.UINT(len(packet)), (*C.WINDIVERT_ADDRESS)(unsafe.Pointer(address)), C.UINT64(flags)) == C.FALSE {
+		return errPacketHeader
+	}
+	return nil
+}
+
+// DecrementTTL decrements the TTL or the HopLimit of packet with
+// WinDivertHelperDecrementTTL, and returns false when it reaches zero
+func DecrementTTL(packet []byte) (bool, error) {
+	if len(packet) == 0 {
+		return false, errPacketShort
+	}
+
+	return C.WinDivertHelperDecrementTTL(unsafe.Pointer(&packet[0]), C.UINT(len(packet))) != C.FALSE, nil
+}
+
+// packetOffsets are the offsets of the headers and the payload of a packet
+// in its buffer, and -1 for a header or a payload which is not present
+type packetOffsets struct {
+	IPv4, IPv6, ICMP, ICMPv6, TCP, UDP int
+	Payload, PayloadLen                int
+	Protocol                           IPProto
+}
+
+// helperParsePacket parses packet with WinDivertHelperParsePacket, for
+// checking ParsePacket against the helper of the DLL
+func helperParsePacket(packet []byte) (packetOffsets, bool) {
+	if len(packet) == 0 {
+		return packetOffsets{}, false
+	}
+
+	ip, ipv6 := C.PWINDIVERT_IPHDR(nil), C.PWINDIVERT_IPV6HDR(nil)
+	icmp, icmpv6 := C.PWINDIVERT_ICMPHDR(nil), C.PWINDIVERT_ICMPV6HDR(nil)
+	tcp, udp := C.PWINDIVERT_TCPHDR(nil), C.PWINDIVERT_UDPHDR(nil)
+	protocol, data, dataLen := C.UINT8(0), C.PVOID(nil), C.UINT(0)
+
+	base := unsafe.Pointer(&packet[0])
+	if C.WinDivertHelperParsePacket(base, C.UINT(len(packet)), &ip, &ipv6, &protocol, &icmp, &icmpv6, &tcp, &udp, &data, &dataLen, nil, nil) == C.FALSE {
+		return packetOffsets{}, false
+	}
+
+	offset := func(p unsafe.Pointer) int {
+		if p == nil {
+			return -1
+		}
+		return int(uintptr(p) - uintptr(base))
+	}
+	return packetOffsets{
+		IPv4:       offset(unsafe.Pointer(ip)),
+		IPv6:       offset(unsafe.Pointer(ipv6)),
+		ICMP:       offset(unsafe.Pointer(icmp)),
+		ICMPv6:     offset(unsafe.Pointer(icmpv6)),
+		TCP:        offset(unsafe.Pointer(tcp)),
+		UDP:        offset(unsafe.Pointer(udp)),
+		Payload:    offset(unsafe.Pointer(data)),
+		PayloadLen: int(dataLen),
+		Protocol:   IPProto(protocol),
+	}, true
+}
+
+// HashPacket returns the hash of packet with WinDivertHelperHashPacket
+func HashPacket(packet []byte, seed uint64) (uint64, error) {
+	if len(packet) == 0 {
+		return 0, errPacketShort
+	}
+
+	return uint64(C.WinDivertHelperHashPacket(unsafe.Pointer(&packet[0]), C.UINT(len(packet)), C.UINT64(seed))), nil
+}
+
+// CompileFilter compiles filter for layer with WinDivertHelperCompileFilter,
+// and the returned object can be passed to Open in place of the filter. A
+// filter which is not valid is reported as a *FilterError.
+func CompileFilter(filter string, layer Layer) (string, error) {
+	filterPtr := C.CString(filter)
+	defer C.free(unsafe.Pointer(filterPtr))
+
+	object := make([]byte, filterObjectLen)
+	errStr, errPos := (*C.char)(nil), C.UINT(0)
+
+	// the last error is read on the thread of the call
+	runtime.LockOSThread()
+	ok := C.WinDivertHelperCompileFilter(filterPtr, C.WINDIVERT_LAYER(layer), (*C.char)(unsafe.Pointer(&object[0])), C.UINT(len(object)), &errStr, &errPos)
+	errno := C.GetLastError()
+	runtime.UnlockOSThread()
+
+	if ok == C.FALSE {
+		if errStr == nil {
+			return "", Error(errno)
+		}
+		return "", &FilterError{Message: C.GoString(errStr), Position: uint(errPos)}
+	}
+
+	return windows.BytePtrToString(&object[0]), nil
+}
+
+// validateFilter compiles filter for WithValidateFilter
+func validateFilter(filter string, layer Layer) error {
+	_, err := CompileFilter(filter, layer)
+	return err
+}
+
+// EvalFilter reports whether packet and address match filter with
+// WinDivertHelperEvalFilter, and packet must be nil for the flow and socket
+// layers
+func EvalFilter(filter string, packet []byte, address *Address) (bool, error) {
+	filterPtr := C.CString(filter)
+	defer C.free(unsafe.Pointer(filterPtr))
+
+	packetPtr := unsafe.Pointer(nil)
+	if len(packet) > 0 {
+		packetPtr = unsafe.Pointer(&packet[0])
+	}
+
+	// the helper only sets the last error when it fails, and returns FALSE
+	// without one for a packet which does not match, so the last error is
+	// cleared first and read on the thread of the call
+	runtime.LockOSThread()
+	C.SetLastError(0)
+	ok := C.WinDivertHelperEvalFilter(filterPtr, packetPtr, C.UINT(len(packet)), (*C.WINDIVERT_ADDRESS)(unsafe.Pointer(address)))
+	errno := C.GetLastError()
+	runtime.UnlockOSThread()
+
+	if ok == C.FALSE {
+		if errno != 0 {
+			return false, Error(errno)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// NormalizeFilter renders filter for layer in the canonical form of
+// WinDivertHelperFormatFilter, so that filters written differently are
+// compared and logged consistently. filter may also be an object returned by
+// CompileFilter. A filter which is not valid is reported as a *FilterError.
+func NormalizeFilter(filter string, layer Layer) (string, error) {
+	filterPtr := C.CString(filter)
+	defer C.free(unsafe.Pointer(filterPtr))
+
+	buffer := make([]byte, filterObjectLen)
+
+	// the last error is read on the thread of the call
+	runtime.LockOSThread()
+	ok := C.WinDivertHelperFormatFilter(filterPtr, C.WINDIVERT_LAYER(layer), (*C.char)(unsafe.Pointer(&buffer[0])), C.UINT(len(buffer)))
+	errno := C.GetLastError()
+	runtime.UnlockOSThread()
+
+	if ok == C.FALSE {
+		if _, err := CompileFilter(filter, layer); err != nil {
+			return "", err
+		}
+		return "", Error(errno)
+	}
+
+	return windows.BytePtrToString(&buffer[0]), nil
 }
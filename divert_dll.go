@@ -4,6 +4,7 @@ package divert
 
 import (
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -18,67 +19,124 @@ var (
 	winDivertOpen = (*windows.Proc)(nil)
 )
 
-func Open(filter string, layer Layer, priority int16, flags uint64) (h *Handle, err error) {
-	once.Do(func() {
-		if er := checkForWow64(); er != nil {
-			err = er
-			return
-		}
+const _LOAD_WITH_ALTERED_SEARCH_PATH = 0x00000008
 
-		dll, er := windows.LoadDLL("WinDivert.dll")
-		if er != nil {
-			err = er
-			return
-		}
-		winDivert = dll
+func loadDLL(path string) (*windows.DLL, error) {
+	if path == "" {
+		return windows.LoadDLL("WinDivert.dll")
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// search the directory of WinDivert.dll first for its dependencies
+	h, err := windows.LoadLibraryEx(abs, 0, _LOAD_WITH_ALTERED_SEARCH_PATH)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %v: %w", abs, err)
+	}
+
+	return &windows.DLL{Name: abs, Handle: h}, nil
+}
+
+var (
+	modVersion                  = windows.NewLazySystemDLL("version.dll")
+	procGetFileVersionInfoSizeW = modVersion.NewProc("GetFileVersionInfoSizeW")
+	procGetFileVersionInfoW     = modVersion.NewProc("GetFileVersionInfoW")
+	procVerQueryValueW          = modVersion.NewProc("VerQueryValueW")
+)
+
+// dllVersion reads the major and minor version from the VS_FIXEDFILEINFO of
+// dll, and returns an empty string when it is not available.
+func dllVersion(dll *windows.DLL) string {
+	name := make([]uint16, windows.MAX_PATH)
+	n, err := windows.GetModuleFileName(dll.Handle, &name[0], uint32(len(name)))
+	if err != nil || n >= uint32(len(name)) {
+		return ""
+	}
+	path := &name[0]
+
+	size, _, _ := procGetFileVersionInfoSizeW.Call(uintptr(unsafe.Pointer(path)), 0)
+	if size == 0 {
+		return ""
+	}
+	info := make([]byte, size)
+	ok, _, _ := procGetFileVersionInfoW.Call(uintptr(unsafe.Pointer(path)), 0, size, uintptr(unsafe.Pointer(&info[0])))
+	if ok == 0 {
+		return ""
+	}
 
-		proc, er := winDivert.FindProc("WinDivertOpen")
-		if er != nil {
-			err = er
-			return
+	root, err := windows.UTF16PtrFromString(`\`)
+	if err != nil {
+		return ""
+	}
+	fixed := (*struct {
+		Signature        uint32
+		StrucVersion     uint32
+		FileVersionMS    uint32
+		FileVersionLS    uint32
+		ProductVersionMS uint32
+		ProductVersionLS uint32
+	})(nil)
+	fixedLen := uint32(0)
+	ok, _, _ = procVerQueryValueW.Call(uintptr(unsafe.Pointer(&info[0])), uintptr(unsafe.Pointer(root)), uintptr(unsafe.Pointer(&fixed)), uintptr(unsafe.Pointer(&fixedLen)))
+	if ok == 0 || fixedLen < uint32(unsafe.Sizeof(*fixed)) {
+		return ""
+	}
+
+	return strings.Join([]string{strconv.Itoa(int(fixed.FileVersionMS >> 16)), strconv.Itoa(int(fixed.FileVersionMS & 0xffff))}, ".")
+}
+
+// loadInit loads WinDivert.dll for load
+var loadInit = initGuard{}
+
+// load loads WinDivert.dll and resolves WinDivertOpen, which is enough
+// for the helpers which do not need the driver
+func load() error {
+	return loadInit.Do(func() error {
+		if err := checkForWow64(); err != nil {
+			return err
 		}
-		winDivertOpen = proc
 
-		vers := map[string]struct{}{
-			"2.0": {},
-			"2.1": {},
-			"2.2": {},
+		dll, err := loadDLL(dllPath)
+		if err != nil {
+			return err
 		}
-		ver, er := func() (ver string, err error) {
-			h, err := open("false", LayerNetwork, PriorityDefault, FlagDefault)
-			if err != nil {
-				return
-			}
-			defer func() {
-				err = h.Close()
-			}()
-
-			major, err := h.GetParam(VersionMajor)
-			if err != nil {
-				return
-			}
-
-			minor, err := h.GetParam(VersionMinor)
-			if err != nil {
-				return
-			}
-
-			ver = strings.Join([]string{strconv.Itoa(int(major)), strconv.Itoa(int(minor))}, ".")
-			return
-		}()
-		if er != nil {
-			err = er
-			return
+
+		proc, err := dll.FindProc("WinDivertOpen")
+		if err != nil {
+			return err
 		}
-		if _, ok := vers[ver]; !ok {
-			err = fmt.Errorf("unsupported windivert version: %v", ver)
+		winDivert, winDivertOpen = dll, proc
+		return nil
+	})
+}
+
+// proc is an export of WinDivert.dll
+type proc = windows.Proc
+
+func Open(filter string, layer Layer, priority int16, flags uint64, opts ...Option) (h *Handle, err error) {
+	err = openInit.Do(func() error {
+		if err := load(); err != nil {
+			return err
 		}
+
+		return checkVersion(dllVersion(winDivert))
 	})
 	if err != nil {
 		return
 	}
 
-	return open(filter, layer, priority, flags)
+	if priority, err = newOptions(opts).check(filter, layer, priority); err != nil {
+		return
+	}
+
+	h, err = open(filter, layer, priority, flags)
+	if err != nil {
+		return nil, explainOpenError(err, filter, layer, flags)
+	}
+	return h, nil
 }
 
 func open(filter string, layer Layer, priority int16, flags uint64) (h *Handle, err error) {
@@ -91,25 +149,25 @@ func open(filter string, layer Layer, priority int16, flags uint64) (h *Handle,
 		return nil, err
 	}
 
+	// flags is a UINT64, which takes two arguments on 32-bit Windows
+	args := []uintptr{uintptr(unsafe.Pointer(filterPtr)), uintptr(layer), uintptr(priority)}
+	args = append(args, uint64Args(flags)...)
+
 	runtime.LockOSThread()
-	hd, _, err := winDivertOpen.Call(uintptr(unsafe.Pointer(filterPtr)), uintptr(layer), uintptr(priority), uintptr(flags))
+	hd, _, err := winDivertOpen.Call(args...)
 	runtime.UnlockOSThread()
+	runtime.KeepAlive(filterPtr)
 
 	if windows.Handle(hd) == windows.InvalidHandle {
 		return nil, Error(err.(windows.Errno))
 	}
 
-	rEvent, _ := windows.CreateEvent(nil, 0, 0, nil)
-	wEvent, _ := windows.CreateEvent(nil, 0, 0, nil)
-
-	return &Handle{
-		Mutex:  sync.Mutex{},
-		Handle: windows.Handle(hd),
-		rOverlapped: windows.Overlapped{
-			HEvent: rEvent,
-		},
-		wOverlapped: windows.Overlapped{
-			HEvent: wEvent,
-		},
-	}, nil
+	return setFinalizer(&Handle{
+		Mutex:    sync.Mutex{},
+		Handle:   windows.Handle(hd),
+		filter:   filter,
+		layer:    layer,
+		priority: priority,
+		flags:    flags,
+	}), nil
 }
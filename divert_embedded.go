@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"runtime"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -22,67 +21,56 @@ var (
 	winDivertOpen = (*memProc)(nil)
 )
 
-func Open(filter string, layer Layer, priority int16, flags uint64) (h *Handle, err error) {
-	once.Do(func() {
-		if er := checkForWow64(); er != nil {
-			err = er
-			return
-		}
+// loadInit loads WinDivert.dll for load
+var loadInit = initGuard{}
 
-		dll, er := loadDLL("WinDivert.dll")
-		if er != nil {
-			err = er
-			return
+// load loads WinDivert.dll from the resource and resolves WinDivertOpen,
+// which is enough for the helpers which do not need the driver
+func load() error {
+	return loadInit.Do(func() error {
+		if err := checkForWow64(); err != nil {
+			return err
 		}
-		winDivert = dll
 
-		proc, er := winDivert.FindProc("WinDivertOpen")
-		if er != nil {
-			err = er
-			return
+		dll, err := loadDLL("WinDivert.dll")
+		if err != nil {
+			return err
 		}
-		winDivertOpen = proc
 
-		vers := map[string]struct{}{
-			"2.0": {},
-			"2.1": {},
-			"2.2": {},
-		}
-		ver, er := func() (ver string, err error) {
-			h, err := open("false", LayerNetwork, PriorityDefault, FlagDefault)
-			if err != nil {
-				return
-			}
-			defer func() {
-				err = h.Close()
-			}()
-
-			major, err := h.GetParam(VersionMajor)
-			if err != nil {
-				return
-			}
-
-			minor, err := h.GetParam(VersionMinor)
-			if err != nil {
-				return
-			}
-
-			ver = strings.Join([]string{strconv.Itoa(int(major)), strconv.Itoa(int(minor))}, ".")
-			return
-		}()
-		if er != nil {
-			err = er
-			return
+		proc, err := dll.FindProc("WinDivertOpen")
+		if err != nil {
+			return err
 		}
-		if _, ok := vers[ver]; !ok {
-			err = fmt.Errorf("unsupported windivert version: %v", ver)
+		winDivert, winDivertOpen = dll, proc
+		return nil
+	})
+}
+
+// proc is an export of WinDivert.dll
+type proc = memProc
+
+func Open(filter string, layer Layer, priority int16, flags uint64, opts ...Option) (h *Handle, err error) {
+	err = openInit.Do(func() error {
+		if err := load(); err != nil {
+			return err
 		}
+
+		// the version of a WinDivert.dll loaded from memory is unknown
+		return checkVersion("")
 	})
 	if err != nil {
 		return
 	}
 
-	return open(filter, layer, priority, flags)
+	if priority, err = newOptions(opts).check(filter, layer, priority); err != nil {
+		return
+	}
+
+	h, err = open(filter, layer, priority, flags)
+	if err != nil {
+		return nil, explainOpenError(err, filter, layer, flags)
+	}
+	return h, nil
 }
 
 func open(filter string, layer Layer, priority int16, flags uint64) (h *Handle, err error) {
@@ -95,27 +83,27 @@ func open(filter string, layer Layer, priority int16, flags uint64) (h *Handle,
 		return nil, err
 	}
 
+	// flags is a UINT64, which takes two arguments on 32-bit Windows
+	args := []uintptr{uintptr(unsafe.Pointer(filterPtr)), uintptr(layer), uintptr(priority)}
+	args = append(args, uint64Args(flags)...)
+
 	runtime.LockOSThread()
-	hd, _, err := winDivertOpen.Call(uintptr(unsafe.Pointer(filterPtr)), uintptr(layer), uintptr(priority), uintptr(flags))
+	hd, _, err := winDivertOpen.Call(args...)
 	runtime.UnlockOSThread()
+	runtime.KeepAlive(filterPtr)
 
 	if windows.Handle(hd) == windows.InvalidHandle {
 		return nil, Error(err.(windows.Errno))
 	}
 
-	rEvent, _ := windows.CreateEvent(nil, 0, 0, nil)
-	wEvent, _ := windows.CreateEvent(nil, 0, 0, nil)
-
-	return &Handle{
-		Mutex:  sync.Mutex{},
-		Handle: windows.Handle(hd),
-		rOverlapped: windows.Overlapped{
-			HEvent: rEvent,
-		},
-		wOverlapped: windows.Overlapped{
-			HEvent: wEvent,
-		},
-	}, nil
+	return setFinalizer(&Handle{
+		Mutex:    sync.Mutex{},
+		Handle:   windows.Handle(hd),
+		filter:   filter,
+		layer:    layer,
+		priority: priority,
+		flags:    flags,
+	}), nil
 }
 
 type memDLL struct {
@@ -140,14 +128,9 @@ func (d *memDLL) Load() error {
 		return nil
 	}
 
-	const ourModule windows.Handle = 0
-	resInfo, err := resource.FindByName(ourModule, d.Name, resource.RT_RCDATA)
+	data, err := loadResource(d.Name)
 	if err != nil {
-		return fmt.Errorf("Unable to find \"%v\" RCDATA resource: %w", d.Name, err)
-	}
-	data, err := resource.Load(ourModule, resInfo)
-	if err != nil {
-		return fmt.Errorf("Unable to load resource: %w", err)
+		return err
 	}
 	module, err := memmod.LoadLibrary(data)
 	if err != nil {
@@ -158,6 +141,20 @@ func (d *memDLL) Load() error {
 	return nil
 }
 
+// loadResource returns the RCDATA resource of name linked into the binary
+func loadResource(name string) ([]byte, error) {
+	const ourModule windows.Handle = 0
+	resInfo, err := resource.FindByName(ourModule, name, resource.RT_RCDATA)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find \"%v\" RCDATA resource: %w", name, err)
+	}
+	data, err := resource.Load(ourModule, resInfo)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load resource: %w", err)
+	}
+	return data, nil
+}
+
 func (d *memDLL) FindProc(name string) (*memProc, error) {
 	proc := &memProc{dll: d, Name: name}
 	err := proc.Find()
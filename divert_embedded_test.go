@@ -0,0 +1,44 @@
+// +build windows,divert_embedded
+
+package divert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEmbeddedDLL compares the WinDivert.dll resource linked into the binary
+// with the SHA-256 pinned in testdata/WinDivert.dll.sha256, which is written by
+//
+//	sha256sum WinDivert.dll > testdata/WinDivert.dll.sha256
+//
+// when the DLL of the resource is updated. It is skipped when the test binary
+// has no resource, which is linked by a .syso file in the package, or when no
+// SHA-256 is pinned.
+func TestEmbeddedDLL(t *testing.T) {
+	data, err := loadResource("WinDivert.dll")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join("testdata", "WinDivert.dll.sha256"))
+	if os.IsNotExist(err) {
+		t.Skip("SHA-256 of the embedded DLL is not pinned")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		t.Fatal("SHA-256 of the embedded DLL is not pinned")
+	}
+
+	sum := sha256.Sum256(data)
+	if got, want := hex.EncodeToString(sum[:]), strings.ToLower(fields[0]); got != want {
+		t.Errorf("SHA-256 of the embedded DLL is %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,335 @@
+// +build windows
+
+// Package dns parses and rewrites the DNS messages of the packets captured by
+// divert, so that a program redirecting or modifying DNS is a Middleware in
+// front of divert.Reinject, such as
+//
+//	h.ForEach(divert.Wrap(divert.Reinject(h), dns.Rewrite(func(m *dns.Message) error {
+//		if m.Response {
+//			m.SetAnswerIP(netip.MustParseAddr("127.0.0.1"), 60)
+//		}
+//		return nil
+//	})))
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+// Port is the port of DNS
+const Port = 53
+
+// the types and the class of the resources
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypeSOA   uint16 = 6
+	TypePTR   uint16 = 12
+	TypeMX    uint16 = 15
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	TypeSRV   uint16 = 33
+
+	ClassINET uint16 = 1
+)
+
+var (
+	errShort   = errors.New("DNS message is shorter than its records")
+	errName    = errors.New("DNS name is not correct")
+	errPointer = errors.New("DNS name has a compression pointer loop")
+	errLength  = errors.New("DNS message exceeds the maximum of a UDP payload")
+)
+
+// Question is a question of a DNS message
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// Resource is a resource record of a DNS message, and Data is its raw data.
+// The names in the data of NS, CNAME, SOA, PTR, MX and SRV records are
+// decompressed by Parse, as the compression pointers do not point at the
+// names anymore after Pack.
+type Resource struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// IP returns the address of an A or AAAA record, and false for the other
+// records
+func (r *Resource) IP() (netip.Addr, bool) {
+	switch {
+	case r.Type == TypeA && len(r.Data) == 4:
+		return netip.AddrFrom4(*(*[4]byte)(r.Data)), true
+	case r.Type == TypeAAAA && len(r.Data) == 16:
+		return netip.AddrFrom16(*(*[16]byte)(r.Data)), true
+	}
+	return netip.Addr{}, false
+}
+
+// Message is a DNS message, and the bits of its header are split into fields
+type Message struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              uint8
+
+	Questions  []Question
+	Answers    []Resource
+	Authority  []Resource
+	Additional []Resource
+}
+
+// Parse parses a DNS message, such as the payload of a UDP packet from or to
+// Port
+func Parse(b []byte) (*Message, error) {
+	if len(b) < 12 {
+		return nil, errShort
+	}
+
+	flags := binary.BigEndian.Uint16(b[2:])
+	m := &Message{
+		ID:                 binary.BigEndian.Uint16(b[0:]),
+		Response:           flags&0x8000 != 0,
+		Opcode:             uint8(flags>>11) & 0x0f,
+		Authoritative:      flags&0x0400 != 0,
+		Truncated:          flags&0x0200 != 0,
+		RecursionDesired:   flags&0x0100 != 0,
+		RecursionAvailable: flags&0x0080 != 0,
+		RCode:              uint8(flags) & 0x0f,
+	}
+
+	off := 12
+	for i := binary.BigEndian.Uint16(b[4:]); i > 0; i-- {
+		name, n, err := parseName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if len(b)-off < 4 {
+			return nil, errShort
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[off:]),
+			Class: binary.BigEndian.Uint16(b[off+2:]),
+		})
+		off += 4
+	}
+
+	for i, records := range []*[]Resource{&m.Answers, &m.Authority, &m.Additional} {
+		for j := binary.BigEndian.Uint16(b[6+2*i:]); j > 0; j-- {
+			name, n, err := parseName(b, off)
+			if err != nil {
+				return nil, err
+			}
+			off = n
+			if len(b)-off < 10 {
+				return nil, errShort
+			}
+			length := int(binary.BigEndian.Uint16(b[off+8:]))
+			if len(b)-off-10 < length {
+				return nil, errShort
+			}
+			typ := binary.BigEndian.Uint16(b[off:])
+			data, err := parseData(b, typ, off+10, length)
+			if err != nil {
+				return nil, err
+			}
+			*records = append(*records, Resource{
+				Name:  name,
+				Type:  typ,
+				Class: binary.BigEndian.Uint16(b[off+2:]),
+				TTL:   binary.BigEndian.Uint32(b[off+4:]),
+				Data:  data,
+			})
+			off += 10 + length
+		}
+	}
+
+	return m, nil
+}
+
+// parseData returns a copy of the data of length at off of a record of typ,
+// in which the names of the records known to contain names are decompressed
+func parseData(b []byte, typ uint16, off, length int) ([]byte, error) {
+	// the fixed fields before and after the names
+	prefix, names, suffix := 0, 1, 0
+	switch typ {
+	case TypeNS, TypeCNAME, TypePTR:
+	case TypeMX:
+		prefix = 2
+	case TypeSRV:
+		prefix = 6
+	case TypeSOA:
+		names, suffix = 2, 20
+	default:
+		return append([]byte(nil), b[off:off+length]...), nil
+	}
+
+	end := off + length
+	if length < prefix+suffix {
+		return nil, errShort
+	}
+	data := append([]byte(nil), b[off:off+prefix]...)
+	off += prefix
+	for i := 0; i < names; i++ {
+		name, n, err := parseName(b[:end], off)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendName(data, name); err != nil {
+			return nil, err
+		}
+		off = n
+	}
+	if end-off != suffix {
+		return nil, errName
+	}
+	return append(data, b[off:end]...), nil
+}
+
+// parseName returns the name at off, decompressed, and the offset after it
+func parseName(b []byte, off int) (string, int, error) {
+	labels := []string(nil)
+	end := -1
+
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errShort
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errShort
+			}
+			if jumps++; jumps > 64 {
+				return "", 0, errPointer
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case n&0xc0 != 0:
+			return "", 0, errName
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errShort
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// Pack returns the message in the wire format, and the names are not
+// compressed
+func (m *Message) Pack() ([]byte, error) {
+	flags := uint16(m.Opcode&0x0f)<<11 | uint16(m.RCode&0x0f)
+	for _, f := range []struct {
+		b   bool
+		bit uint16
+	}{{m.Response, 0x8000}, {m.Authoritative, 0x0400}, {m.Truncated, 0x0200}, {m.RecursionDesired, 0x0100}, {m.RecursionAvailable, 0x0080}} {
+		if f.b {
+			flags |= f.bit
+		}
+	}
+
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.Type)
+		b = appendUint16(b, q.Class)
+	}
+	for _, records := range [][]Resource{m.Answers, m.Authority, m.Additional} {
+		for _, r := range records {
+			if b, err = appendName(b, r.Name); err != nil {
+				return nil, err
+			}
+			b = appendUint16(b, r.Type)
+			b = appendUint16(b, r.Class)
+			b = appendUint16(b, uint16(r.TTL>>16))
+			b = appendUint16(b, uint16(r.TTL))
+			b = appendUint16(b, uint16(len(r.Data)))
+			b = append(b, r.Data...)
+		}
+	}
+
+	if len(b) > 0xffff-8 {
+		return nil, errLength
+	}
+	return b, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return append(b, 0), nil
+	}
+	if len(name) > 253 {
+		return nil, errName
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errName
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// SetAnswerIP replaces the answers of a response with an A or an AAAA record
+// of addr for the first question, and clears the error of the response
+func (m *Message) SetAnswerIP(addr netip.Addr, ttl uint32) {
+	name := "."
+	if len(m.Questions) > 0 {
+		name = m.Questions[0].Name
+	}
+
+	r := Resource{Name: name, Type: TypeA, Class: ClassINET, TTL: ttl}
+	if addr.Is4() {
+		a := addr.As4()
+		r.Data = a[:]
+	} else {
+		r.Type = TypeAAAA
+		a := addr.As16()
+		r.Data = a[:]
+	}
+
+	m.Response = true
+	m.RCode = 0
+	m.Answers = []Resource{r}
+}
@@ -0,0 +1,59 @@
+// +build windows
+
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPackCompressedData(t *testing.T) {
+	// a response to www.example.com A: a CNAME to example.com and an A of
+	// example.com, both names compressed against the question
+	msg := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0,
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1,
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 16,
+		0xc0, 16, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 93, 184, 216, 34,
+	}
+
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Answers[1].TTL = 30
+
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cname, _, err := parseName(m.Answers[0].Data, 0)
+	if err != nil || cname != "example.com." {
+		t.Errorf("CNAME is %q, %v, want example.com.", cname, err)
+	}
+	if m.Answers[1].Name != "example.com." || !bytes.Equal(m.Answers[1].Data, []byte{93, 184, 216, 34}) {
+		t.Errorf("A record is %v %v", m.Answers[1].Name, m.Answers[1].Data)
+	}
+}
+
+func TestParseDataMX(t *testing.T) {
+	msg := []byte{
+		0, 1, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 15, 0, 1,
+		0xc0, 12, 0, 15, 0, 1, 0, 0, 0, 60, 0, 9, 0, 10, 4, 'm', 'a', 'i', 'l', 0xc0, 12,
+	}
+
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{0, 10, 4, 'm', 'a', 'i', 'l'}, msg[12:25]...)
+	if !bytes.Equal(m.Answers[0].Data, want) {
+		t.Errorf("MX data is %v, want %v", m.Answers[0].Data, want)
+	}
+}
@@ -0,0 +1,86 @@
+// +build windows
+
+package dns
+
+import (
+	"errors"
+
+	"github.com/imgk/divert-go"
+)
+
+var errNotDNS = errors.New("Packet is not a UDP packet from or to the DNS port")
+
+// ErrDrop is returned by the function of Rewrite to drop the packet rather
+// than pass it on
+var ErrDrop = errors.New("The DNS packet is dropped")
+
+// ParsePacket parses the DNS message of p, which is a UDP packet from or to
+// Port
+func ParsePacket(p *divert.Packet) (*Message, error) {
+	if p.UDP == nil || p.Fragment || (p.UDP.SrcPort() != Port && p.UDP.DstPort() != Port) {
+		return nil, errNotDNS
+	}
+	return Parse(p.Payload)
+}
+
+// RewritePacket parses the DNS message of packet, passes it to fn and packs
+// the message back into the packet, of which the IP and UDP lengths and the
+// checksums are fixed. The returned packet may not share the memory of
+// packet, which is resized for the message.
+func RewritePacket(packet []byte, fn func(*Message) error) ([]byte, error) {
+	p, err := divert.ParsePacket(packet)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParsePacket(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(m); err != nil {
+		return nil, err
+	}
+
+	b, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.SetPayload(b); err != nil {
+		return nil, err
+	}
+	p.CalcChecksums(divert.ChecksumDefault)
+
+	return p.Buffer, nil
+}
+
+// Rewrite returns a Middleware which passes the DNS message of every DNS
+// packet to fn and the rewritten packet to next, with a copy of the address
+// whose checksum flags are set as the checksums are valid. The other packets
+// and the DNS messages which can not be parsed are passed on untouched, and
+// a packet is dropped when fn returns ErrDrop.
+func Rewrite(fn func(*Message) error) divert.Middleware {
+	return func(next divert.Handler) divert.Handler {
+		return func(packet []byte, address *divert.Address) error {
+			p, err := divert.ParsePacket(packet)
+			if err != nil {
+				return next(packet, address)
+			}
+			if _, err := ParsePacket(p); err != nil {
+				return next(packet, address)
+			}
+
+			b, err := RewritePacket(packet, fn)
+			switch {
+			case err == ErrDrop:
+				return nil
+			case err != nil:
+				return err
+			}
+
+			addr := *address
+			addr.SetIPChecksum(p.IPv4 != nil)
+			addr.SetUDPChecksum(true)
+			return next(b, &addr)
+		}
+	}
+}
@@ -5,18 +5,194 @@ package divert
 import (
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 
 	"golang.org/x/sys/windows"
 )
 
 var (
-	errQueueLength = fmt.Errorf("Queue length is not correct, Max: %v, Min: %v", QueueLengthMax, QueueLengthMin)
-	errQueueTime   = fmt.Errorf("Queue time is not correct, Max: %v, Min: %v", QueueTimeMax, QueueTimeMin)
-	errQueueSize   = fmt.Errorf("Queue size is not correct, Max: %v, Min: %v", QueueSizeMax, QueueSizeMin)
-	errQueueParam  = errors.New("VersionMajor and VersionMinor only can be used in function GetParam")
-	errPriority    = fmt.Errorf("Priority is not Correct, Max: %v, Min: %v", PriorityHighest, PriorityLowest)
+	errQueueLength     = fmt.Errorf("Queue length is not correct, Max: %v, Min: %v", QueueLengthMax, QueueLengthMin)
+	errQueueTime       = fmt.Errorf("Queue time is not correct, Max: %v, Min: %v", QueueTimeMax, QueueTimeMin)
+	errQueueSize       = fmt.Errorf("Queue size is not correct, Max: %v, Min: %v", QueueSizeMax, QueueSizeMin)
+	errQueueParam      = errors.New("VersionMajor and VersionMinor only can be used in function GetParam")
+	errPriority        = fmt.Errorf("Priority is not Correct, Max: %v, Min: %v", PriorityHighest, PriorityLowest)
+	errBatchSize       = fmt.Errorf("Number of addresses is not correct, Max: %v, Min: %v", BatchMax, 1)
+	errMergeLayer      = errors.New("Filters do not compile for a common layer")
+	errSelfTest        = errors.New("The packet injected by SelfTest was not captured")
+	errFilterObject    = errors.New("Filter object is not compiled by CompileFilter")
+	errSocketLayer     = errors.New("Address or handle is not on the socket layer")
+	errSocketVerdict   = errors.New("The verdict of a socket operation is made by the flags of the handle, FlagSniff allows and no FlagSniff blocks")
+	errRecvPending     = errors.New("A receive started by RecvStart is pending")
+	errRecvNotPending  = errors.New("No receive is started by RecvStart")
+	errIOPending       = errors.New("An operation is pending on the overlapped of the handle")
+	errPcapHeader      = errors.New("File is not a pcap file of raw IP packets")
+	errPcapPacket      = errors.New("Packet of the pcap file is longer than the snap length")
+	errSendConcurrency = errors.New("Send concurrency is less than 1")
+	errSendStarted     = errors.New("Send concurrency can only be set before the first send")
+	errReflectLayer    = errors.New("Address is not on the reflect layer")
+	errBatchPackets    = errors.New("Number of packets in the buffer does not match the number of addresses")
 )
 
+var (
+	errPacketShort     = errors.New("Packet is shorter than its headers")
+	errPacketVersion   = errors.New("Packet is neither IPv4 nor IPv6")
+	errPacketHeader    = errors.New("Packet has a header length which is not correct")
+	errPacketLength    = errors.New("Packet length exceeds the maximum of IP packet")
+	errPacketTransport = errors.New("Packet has no TCP, UDP or ICMPv6 header")
+	errICMPError       = errors.New("Packet is an ICMP error or a fragment which an ICMP error is not sent for")
+)
+
+// ErrVersionMismatch is returned by Open when the version of WinDivert.dll
+// does not match the version of the WinDivert driver which is running
+var ErrVersionMismatch = errors.New("WinDivert.dll and the WinDivert driver versions do not match")
+
+// ErrClosed is returned by the methods of a Handle after Close
+var ErrClosed = errors.New("Handle is closed")
+
+// closeErrors are the errors of closing the handle and its events, and Close
+// tries to close all of them
+type closeErrors []error
+
+func (e closeErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Unwrap returns the errors for errors.Is and errors.As
+func (e closeErrors) Unwrap() []error {
+	return e
+}
+
+// Is reports whether any of the errors is target, as errors.Is does not
+// follow Unwrap() []error before Go 1.20
+func (e closeErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors which matches target, as Is does for
+// errors.Is
+func (e closeErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns nil for no error, the error for one error, or e
+func (e closeErrors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
+
+// ErrWrongDirection is returned by Send on a handle opened with FlagRecvOnly,
+// and by Recv on a handle opened with FlagSendOnly
+var ErrWrongDirection = errors.New("Handle is not opened for the direction of the operation")
+
+// ErrShortWrite is returned by Send and SendEx with the number of bytes sent,
+// when the driver accepts fewer bytes than the buffer, as an io.Writer does
+var ErrShortWrite = io.ErrShortWrite
+
+// ErrUnsupportedHelper is returned by a helper when WinDivert.dll does not
+// export the function of it
+var ErrUnsupportedHelper = errors.New("WinDivert.dll does not export the helper")
+
+// ErrPriorityConflict is returned by Open with WithPriorityCheck when a handle
+// is already open on the layer with the priority, and the order in which the
+// handles see a packet is then undefined
+var ErrPriorityConflict = errors.New("A handle is already open on the layer with the priority")
+
+// FilterError is a filter string which is not valid, with the message and
+// the position reported by WinDivertHelperCompileFilter
+type FilterError struct {
+	Message  string
+	Position uint
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%v at position %v", e.Message, e.Position)
+}
+
+// OpenError is an ErrInvalidParameter of Open with the likely causes found
+// by checking the arguments, and Filter is the error of the filter when
+// WinDivertHelperCompileFilter rejects it. It unwraps to ErrInvalidParameter.
+type OpenError struct {
+	Err    error
+	Filter *FilterError
+	Causes []string
+}
+
+func (e *OpenError) Error() string {
+	if len(e.Causes) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %v", e.Err, strings.Join(e.Causes, "; "))
+}
+
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// explainOpenError returns an *OpenError for an ErrInvalidParameter of Open,
+// and err itself otherwise
+func explainOpenError(err error, filter string, layer Layer, flags uint64) error {
+	if err != ErrInvalidParameter {
+		return err
+	}
+
+	e := &OpenError{Err: err}
+	if ferr := validateFilter(filter, layer); ferr != nil {
+		if fe, ok := ferr.(*FilterError); ok {
+			e.Filter = fe
+			e.Causes = append(e.Causes, fmt.Sprintf("filter %q is not valid: %v", filter, fe))
+		}
+	}
+
+	if layer.String() == "" {
+		e.Causes = append(e.Causes, fmt.Sprintf("layer %d is unknown", layer))
+	}
+	if flags&^(FlagSniff|FlagDrop|FlagRecvOnly|FlagSendOnly|FlagNoInstall|FlagFragments) != 0 {
+		e.Causes = append(e.Causes, fmt.Sprintf("flags %v has unknown flags", flagNames(flags)))
+	}
+	if flags&FlagSniff != 0 && flags&FlagDrop != 0 {
+		e.Causes = append(e.Causes, "FlagSniff and FlagDrop exclude each other")
+	}
+	if flags&FlagRecvOnly != 0 && flags&FlagSendOnly != 0 {
+		e.Causes = append(e.Causes, "FlagRecvOnly and FlagSendOnly exclude each other")
+	}
+	switch layer {
+	case LayerFlow, LayerReflect:
+		if flags&FlagSniff == 0 || flags&FlagRecvOnly == 0 {
+			e.Causes = append(e.Causes, fmt.Sprintf("%v needs FlagSniff and FlagRecvOnly", layer))
+		}
+	case LayerSocket:
+		if flags&FlagRecvOnly == 0 {
+			e.Causes = append(e.Causes, fmt.Sprintf("%v needs FlagRecvOnly", layer))
+		}
+	}
+	if flags&FlagFragments != 0 && layer != LayerNetwork && layer != LayerNetworkForward {
+		e.Causes = append(e.Causes, "FlagFragments is only for the network layers")
+	}
+
+	return e
+}
+
 var (
 	// The driver files WinDivert32.sys or WinDivert64.sys were not found
 	ErrFileNotFound = Error(windows.ERROR_FILE_NOT_FOUND)
@@ -48,6 +224,9 @@ var (
 	// The error code ERROR_IO_PENDING indicates that the overlapped operation has been successfully initiated and that completion will be indicated at a later time
 	ErrIOPending = Error(windows.ERROR_IO_PENDING)
 
+	// The overlapped I/O operation is still in progress
+	ErrIOIncomplete = Error(windows.ERROR_IO_INCOMPLETE)
+
 	// This error occurs when an impostor packet (with pAddr->Impostor set to 1) is injected and the ip.TTL or ipv6.HopLimit field goes to zero. This is a defense of "last resort" against infinite loops caused by impostor packets
 	ErrHostUnreachable = Error(windows.ERROR_HOST_UNREACHABLE)
 
@@ -59,11 +238,37 @@ var (
 
 	// The handle is invalid
 	ErrInvalidHandle = Error(windows.ERROR_INVALID_HANDLE)
+
+	// No packet is received before the timeout of RecvTimeout
+	ErrTimeout = Error(windows.WAIT_TIMEOUT)
+
+	// No packet is queued when a non-blocking handle receives
+	ErrWouldBlock = Error(windows.WSAEWOULDBLOCK)
+
+	// The control code is not an IOCTL of WinDivert
+	ErrInvalidFunction = Error(windows.ERROR_INVALID_FUNCTION)
 )
 
 type Error windows.Errno
 
+// Code returns the Windows error code
+func (e Error) Code() uint32 {
+	return uint32(e)
+}
+
+// Message returns the message of the Windows error code by FormatMessage,
+// which is localized by the system
+func (e Error) Message() string {
+	return windows.Errno(e).Error()
+}
+
+// Error describes the error as the WinDivert documentation does, followed by
+// the Windows error code
 func (e Error) Error() string {
+	return fmt.Sprintf("%v (error %v)", e.description(), e.Code())
+}
+
+func (e Error) description() string {
 	switch windows.Errno(e) {
 	case windows.ERROR_FILE_NOT_FOUND:
 		return "The driver files WinDivert32.sys or WinDivert64.sys were not found"
@@ -85,6 +290,8 @@ func (e Error) Error() string {
 		return "The handle has been shutdown using WinDivertShutdown() and the packet queue is empty"
 	case windows.ERROR_IO_PENDING:
 		return "The error code ERROR_IO_PENDING indicates that the overlapped operation has been successfully initiated and that completion will be indicated at a later time"
+	case windows.ERROR_IO_INCOMPLETE:
+		return "The overlapped I/O operation is still in progress"
 	case windows.ERROR_HOST_UNREACHABLE:
 		return "This error occurs when an impostor packet (with pAddr->Impostor set to 1) is injected and the ip.TTL or ipv6.HopLimit field goes to zero. This is a defense of \"last resort\" against infinite loops caused by impostor packets"
 	case windows.EPT_S_NOT_REGISTERED:
@@ -93,7 +300,13 @@ func (e Error) Error() string {
 		return "The I/O operation has been aborted because of either a thread exit or an application request"
 	case windows.ERROR_INVALID_HANDLE:
 		return "The handle is invalid"
+	case windows.WAIT_TIMEOUT:
+		return "No packet is received before the timeout"
+	case windows.WSAEWOULDBLOCK:
+		return "No packet is queued, and the handle is non-blocking"
+	case windows.ERROR_INVALID_FUNCTION:
+		return "The control code is not an IOCTL of WinDivert"
 	default:
-		return windows.Errno(e).Error()
+		return e.Message()
 	}
 }
@@ -0,0 +1,32 @@
+// +build windows
+
+package divert
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCloseErrors(t *testing.T) {
+	filterErr := &FilterError{Message: "unexpected token", Position: 3}
+	err := closeErrors{ErrClosed, filterErr}.err()
+
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("errors.Is(%v, ErrClosed) is false", err)
+	}
+	if errors.Is(err, ErrNoData) {
+		t.Errorf("errors.Is(%v, ErrNoData) is true", err)
+	}
+
+	target := (*FilterError)(nil)
+	if !errors.As(err, &target) || target != filterErr {
+		t.Errorf("errors.As(%v) finds %v, want %v", err, target, filterErr)
+	}
+
+	if err := (closeErrors{ErrClosed}).err(); err != ErrClosed {
+		t.Errorf("one error is %v, want ErrClosed", err)
+	}
+	if err := (closeErrors{}).err(); err != nil {
+		t.Errorf("no error is %v, want nil", err)
+	}
+}
@@ -0,0 +1,46 @@
+// +build windows
+
+package main
+
+import (
+	"log"
+
+	"github.com/imgk/divert-go"
+)
+
+// This example rewrites the TTL of forwarded ICMP packets and injects them as
+// impostors, so that they are diverted again by other handles. The handle
+// skips impostor packets in its filter, or it would divert its own packets
+// again and again.
+func main() {
+	h, err := divert.Open("icmp and not impostor", divert.LayerNetworkForward, divert.PriorityDefault, divert.FlagDefault)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+
+	buffer := make([]byte, divert.MTUMax)
+	address := divert.Address{}
+	for {
+		n, err := h.Recv(buffer, &address)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		p, err := divert.ParsePacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+		if p.IPv4 != nil {
+			p.IPv4.SetTTL(64)
+		} else {
+			p.IPv6.SetHopLimit(64)
+		}
+		p.CalcChecksums(divert.ChecksumDefault)
+
+		address.SetImpostor(true)
+		if _, err := h.Send(p.Buffer, &address); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// +build windows
+
+package main
+
+import (
+	"log"
+
+	"github.com/imgk/divert-go"
+	"golang.org/x/sys/windows"
+)
+
+// This example keeps several receives in flight on one handle, each with its
+// own Overlapped, and reaps them from a completion port in the order they
+// complete, so that no receive waits for another.
+func main() {
+	h, err := divert.Open("tcp", divert.LayerNetwork, divert.PriorityDefault, divert.FlagSniff)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close()
+
+	port, err := h.AssociateCompletionPort(0, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer windows.CloseHandle(port)
+
+	const n = 8
+	for i := 0; i < n; i++ {
+		o, err := divert.NewOverlapped()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer o.Close()
+
+		if err := h.RecvAsync(make([]byte, divert.MTUMax), make([]divert.Address, 1), o); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for {
+		iolen, key, overlapped := uint32(0), uint32(0), (*windows.Overlapped)(nil)
+		if err := windows.GetQueuedCompletionStatus(port, &iolen, &key, &overlapped, windows.INFINITE); err != nil {
+			log.Fatal(err)
+		}
+
+		o := divert.OverlappedOf(overlapped)
+		p, err := divert.ParsePacket(o.Buffer()[:iolen])
+		if err == nil && p.TCP != nil {
+			log.Printf("%v bytes, port %v -> %v", iolen, p.TCP.SrcPort(), p.TCP.DstPort())
+		}
+
+		if err := h.RecvAsync(o.Buffer(), o.Addresses()[:1], o); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// +build windows
+
+// Package filter builds WinDivert filter strings from expressions, so that
+// filters can be composed, changed and simplified at run time, and rendered
+// to a string accepted by divert.Open and divert.CompileFilter.
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr is a WinDivert filter expression, and String renders it as a filter
+// string
+type Expr interface {
+	String() string
+}
+
+// Op is a comparison operator
+type Op string
+
+const (
+	OpEq Op = "=="
+	OpNe Op = "!="
+	OpLt Op = "<"
+	OpLe Op = "<="
+	OpGt Op = ">"
+	OpGe Op = ">="
+)
+
+// negate returns the operator of the negated comparison
+func (op Op) negate() Op {
+	switch op {
+	case OpEq:
+		return OpNe
+	case OpNe:
+		return OpEq
+	case OpLt:
+		return OpGe
+	case OpLe:
+		return OpGt
+	case OpGt:
+		return OpLe
+	case OpGe:
+		return OpLt
+	}
+	return op
+}
+
+// Field is a field of a packet or an address, such as "tcp.DstPort" or
+// "packet[0]", and it is true alone when its value is not zero
+type Field string
+
+func (f Field) String() string {
+	return string(f)
+}
+
+var (
+	// True matches every packet
+	True Expr = Field("true")
+
+	// False matches no packet
+	False Expr = Field("false")
+)
+
+// Comparison compares a field with a number, an address, or a constant such
+// as TCP
+type Comparison struct {
+	Field Field
+	Op    Op
+	Value string
+}
+
+func (c *Comparison) String() string {
+	return fmt.Sprintf("%v %v %v", c.Field, c.Op, c.Value)
+}
+
+// AndExpr is true when all the expressions are true, and an empty AndExpr is
+// true
+type AndExpr []Expr
+
+func (e AndExpr) String() string {
+	return join(e, " and ", "true")
+}
+
+// OrExpr is true when any of the expressions is true, and an empty OrExpr is
+// false
+type OrExpr []Expr
+
+func (e OrExpr) String() string {
+	return join(e, " or ", "false")
+}
+
+// join renders exprs with sep, and puts every AndExpr and OrExpr of more than
+// one expression in parentheses, so that the rendering never depends on the
+// precedence of and over or
+func join(exprs []Expr, sep, empty string) string {
+	if len(exprs) == 0 {
+		return empty
+	}
+
+	ss := make([]string, 0, len(exprs))
+	for _, x := range exprs {
+		if n, ok := x.(NotExpr); ok {
+			// a negated expression may be rendered as an OrExpr
+			x = n.push()
+		}
+		if grouped(x) {
+			ss = append(ss, "("+x.String()+")")
+			continue
+		}
+		ss = append(ss, x.String())
+	}
+	return strings.Join(ss, sep)
+}
+
+// grouped reports whether x is rendered in parentheses by join
+func grouped(x Expr) bool {
+	switch x := x.(type) {
+	case AndExpr:
+		return len(x) > 1
+	case OrExpr:
+		return len(x) > 1
+	}
+	return false
+}
+
+// NotExpr is true when the expression is false. WinDivert only negates a
+// single test, so NotExpr is rendered with the negation pushed down to the
+// fields and comparisons, by De Morgan's laws.
+type NotExpr struct {
+	Expr Expr
+}
+
+func (e NotExpr) String() string {
+	switch e.Expr.(type) {
+	case *Comparison, NotExpr, AndExpr, OrExpr:
+		return e.push().String()
+	}
+	return "not " + e.Expr.String()
+}
+
+// push returns the expression with the negation pushed down one level
+func (e NotExpr) push() Expr {
+	switch x := e.Expr.(type) {
+	case *Comparison:
+		return &Comparison{Field: x.Field, Op: x.Op.negate(), Value: x.Value}
+	case NotExpr:
+		return x.Expr
+	case AndExpr:
+		or := make(OrExpr, 0, len(x))
+		for _, y := range x {
+			or = append(or, Not(y))
+		}
+		return or
+	case OrExpr:
+		and := make(AndExpr, 0, len(x))
+		for _, y := range x {
+			and = append(and, Not(y))
+		}
+		return and
+	}
+	return e
+}
+
+// And returns an expression which is true when all of exprs are true
+func And(exprs ...Expr) Expr {
+	return AndExpr(exprs)
+}
+
+// Or returns an expression which is true when any of exprs is true
+func Or(exprs ...Expr) Expr {
+	return OrExpr(exprs)
+}
+
+// Not returns an expression which is true when expr is false
+func Not(expr Expr) Expr {
+	return NotExpr{Expr: expr}
+}
+
+// Is returns an expression which is true when field is not zero, such as
+// Is("tcp") or Is("outbound")
+func Is(field Field) Expr {
+	return field
+}
+
+// Outbound and Inbound return expressions matching the outbound and the
+// inbound packets, which are not available on the network forward layer and
+// are rejected there by ValidateLayer
+func Outbound() Expr { return Field("outbound") }
+func Inbound() Expr  { return Field("inbound") }
+
+// Length returns an expression comparing the length of the packet with n by
+// op, such as Length(OpGt, 1400) for the packets longer than 1400 bytes. The
+// length field is only available on the network layers, and is rejected on
+// the other layers by ValidateLayer.
+func Length(op Op, n int) Expr {
+	return Compare("length", op, n)
+}
+
+// Compare returns an expression comparing field with value, which may be an
+// integer, a bool, a fmt.Stringer such as net.IP and netip.Addr, or a string
+// used as it is
+func Compare(field Field, op Op, value interface{}) Expr {
+	return &Comparison{Field: field, Op: op, Value: formatValue(value)}
+}
+
+// Eq, Ne, Lt, Le, Gt and Ge compare field with value by the operator of
+// their names, as Compare does
+func Eq(field Field, value interface{}) Expr { return Compare(field, OpEq, value) }
+func Ne(field Field, value interface{}) Expr { return Compare(field, OpNe, value) }
+func Lt(field Field, value interface{}) Expr { return Compare(field, OpLt, value) }
+func Le(field Field, value interface{}) Expr { return Compare(field, OpLe, value) }
+func Gt(field Field, value interface{}) Expr { return Compare(field, OpGt, value) }
+func Ge(field Field, value interface{}) Expr { return Compare(field, OpGe, value) }
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// Render renders expr as a WinDivert filter string
+func Render(expr Expr) string {
+	return expr.String()
+}
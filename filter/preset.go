@@ -0,0 +1,76 @@
+// +build windows
+
+package filter
+
+import (
+	"fmt"
+
+	"github.com/imgk/divert-go"
+)
+
+// The presets are expressions of common filters, which pass Validate and are
+// composed with And, Or and Not like any expression, such as
+//
+//	And(PresetOutboundTCP(), Not(PresetHTTPS()))
+
+// PresetPort returns an expression matching the packets of proto, which is
+// divert.ProtoTCP or divert.ProtoUDP, from or to port
+func PresetPort(proto divert.IPProto, port uint16) (Expr, error) {
+	prefix := ""
+	switch proto {
+	case divert.ProtoTCP:
+		prefix = "tcp"
+	case divert.ProtoUDP:
+		prefix = "udp"
+	default:
+		return nil, fmt.Errorf("Protocol %v has no ports", proto)
+	}
+	return Or(Eq(Field(prefix+".SrcPort"), port), Eq(Field(prefix+".DstPort"), port)), nil
+}
+
+// ports is PresetPort of a protocol which has ports
+func ports(proto divert.IPProto, port uint16) Expr {
+	e, _ := PresetPort(proto, port)
+	return e
+}
+
+// PresetDNS matches DNS over UDP and TCP on port 53
+func PresetDNS() Expr {
+	return Or(ports(divert.ProtoUDP, 53), ports(divert.ProtoTCP, 53))
+}
+
+// PresetHTTP matches HTTP on TCP port 80
+func PresetHTTP() Expr {
+	return ports(divert.ProtoTCP, 80)
+}
+
+// PresetHTTPS matches HTTPS on TCP port 443, and QUIC on UDP port 443
+func PresetHTTPS() Expr {
+	return Or(ports(divert.ProtoTCP, 443), ports(divert.ProtoUDP, 443))
+}
+
+// PresetICMP matches ICMP and ICMPv6
+func PresetICMP() Expr {
+	return Or(Is("icmp"), Is("icmpv6"))
+}
+
+// PresetOutboundTCP matches the outbound TCP packets
+func PresetOutboundTCP() Expr {
+	return And(Outbound(), Is("tcp"))
+}
+
+// PresetInboundTCP matches the inbound TCP packets
+func PresetInboundTCP() Expr {
+	return And(Inbound(), Is("tcp"))
+}
+
+// PresetTCPSyn matches the SYN which opens a TCP connection, and not the
+// SYN-ACK answering it
+func PresetTCPSyn() Expr {
+	return And(Is("tcp.Syn"), Not(Is("tcp.Ack")))
+}
+
+// PresetLoopback matches the packets of the loopback interface
+func PresetLoopback() Expr {
+	return Is("loopback")
+}
@@ -0,0 +1,86 @@
+// +build windows
+
+package filter
+
+// Simplify returns an equivalent expression, with nested AndExpr and OrExpr
+// flattened, true and false folded, duplicated expressions removed, and the
+// negations pushed down to the fields and comparisons
+func Simplify(expr Expr) Expr {
+	switch x := expr.(type) {
+	case NotExpr:
+		if f, ok := x.Expr.(Field); ok {
+			switch f {
+			case "true":
+				return False
+			case "false":
+				return True
+			}
+			return x
+		}
+		switch x.Expr.(type) {
+		case *Comparison, NotExpr, AndExpr, OrExpr:
+			return Simplify(x.push())
+		}
+		return x
+	case AndExpr:
+		return simplify(x, "true", "false", func(e Expr) ([]Expr, bool) {
+			and, ok := e.(AndExpr)
+			return and, ok
+		}, And)
+	case OrExpr:
+		return simplify(x, "false", "true", func(e Expr) ([]Expr, bool) {
+			or, ok := e.(OrExpr)
+			return or, ok
+		}, Or)
+	}
+	return expr
+}
+
+func isConst(e Expr, c Field) bool {
+	f, ok := e.(Field)
+	return ok && f == c
+}
+
+// simplify flattens exprs of the same kind, drops the identity and returns
+// the absorbing element when it is found
+func simplify(exprs []Expr, identity, absorbing Field, same func(Expr) ([]Expr, bool), build func(...Expr) Expr) Expr {
+	flat := []Expr{}
+	seen := map[string]bool{}
+
+	var add func(Expr) bool
+	add = func(e Expr) bool {
+		e = Simplify(e)
+		if sub, ok := same(e); ok {
+			for _, x := range sub {
+				if !add(x) {
+					return false
+				}
+			}
+			return true
+		}
+		switch {
+		case isConst(e, absorbing):
+			return false
+		case isConst(e, identity):
+			return true
+		}
+		if s := e.String(); !seen[s] {
+			seen[s] = true
+			flat = append(flat, e)
+		}
+		return true
+	}
+
+	for _, e := range exprs {
+		if !add(e) {
+			return absorbing
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return identity
+	case 1:
+		return flat[0]
+	}
+	return build(flat...)
+}
@@ -0,0 +1,223 @@
+// +build windows
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/imgk/divert-go"
+)
+
+// the layers of a field, following WinDivertValidateField in windivert_shared.c
+const (
+	lN = 1 << divert.LayerNetwork
+	lM = 1 << divert.LayerNetworkForward
+	lF = 1 << divert.LayerFlow
+	lS = 1 << divert.LayerSocket
+	lR = 1 << divert.LayerReflect
+)
+
+func layerBit(layer divert.Layer) uint8 {
+	if layer < divert.LayerNetwork || layer > divert.LayerReflect {
+		return 0
+	}
+	return 1 << layer
+}
+
+type fieldInfo struct {
+	layers uint8
+
+	// array is set for the fields which take an index such as packet[0] or
+	// tcp.Payload16[-1]
+	array bool
+}
+
+// fields are the fields of the WinDivert filter language
+var fields = map[string]fieldInfo{
+	"true":  {lN | lM | lF | lS | lR, false},
+	"false": {lN | lM | lF | lS | lR, false},
+	"zero":  {lN | lM | lF | lS | lR, false},
+	"event": {lN | lM | lF | lS | lR, false},
+
+	"timestamp": {lN | lM | lF | lS | lR, false},
+	"layer":     {lR, false},
+	"priority":  {lR, false},
+	"length":    {lN | lM, false},
+	"random8":   {lN | lM, false},
+	"random16":  {lN | lM, false},
+	"random32":  {lN | lM, false},
+	"fragment":  {lN | lM, false},
+
+	"inbound":  {lN | lF | lS, false},
+	"outbound": {lN | lF | lS, false},
+	"loopback": {lN | lF | lS, false},
+	"impostor": {lN | lM, false},
+	"ifIdx":    {lN | lM, false},
+	"subIfIdx": {lN | lM, false},
+
+	"processId":        {lF | lS | lR, false},
+	"localAddr":        {lN | lF | lS, false},
+	"remoteAddr":       {lN | lF | lS, false},
+	"localPort":        {lN | lF | lS, false},
+	"remotePort":       {lN | lF | lS, false},
+	"protocol":         {lN | lF | lS, false},
+	"endpointId":       {lF | lS, false},
+	"parentEndpointId": {lF | lS, false},
+
+	"ip":     {lN | lM | lF | lS, false},
+	"ipv6":   {lN | lM | lF | lS, false},
+	"icmp":   {lN | lM | lF | lS, false},
+	"icmpv6": {lN | lM | lF | lS, false},
+	"tcp":    {lN | lM | lF | lS, false},
+	"udp":    {lN | lM | lF | lS, false},
+
+	"ip.HdrLength": {lN | lM, false},
+	"ip.TOS":       {lN | lM, false},
+	"ip.Length":    {lN | lM, false},
+	"ip.Id":        {lN | lM, false},
+	"ip.DF":        {lN | lM, false},
+	"ip.MF":        {lN | lM, false},
+	"ip.FragOff":   {lN | lM, false},
+	"ip.TTL":       {lN | lM, false},
+	"ip.Protocol":  {lN | lM, false},
+	"ip.Checksum":  {lN | lM, false},
+	"ip.SrcAddr":   {lN | lM, false},
+	"ip.DstAddr":   {lN | lM, false},
+
+	"ipv6.TrafficClass": {lN | lM, false},
+	"ipv6.FlowLabel":    {lN | lM, false},
+	"ipv6.Length":       {lN | lM, false},
+	"ipv6.NextHdr":      {lN | lM, false},
+	"ipv6.HopLimit":     {lN | lM, false},
+	"ipv6.SrcAddr":      {lN | lM, false},
+	"ipv6.DstAddr":      {lN | lM, false},
+
+	"icmp.Type":       {lN | lM, false},
+	"icmp.Code":       {lN | lM, false},
+	"icmp.Checksum":   {lN | lM, false},
+	"icmp.Body":       {lN | lM, false},
+	"icmpv6.Type":     {lN | lM, false},
+	"icmpv6.Code":     {lN | lM, false},
+	"icmpv6.Checksum": {lN | lM, false},
+	"icmpv6.Body":     {lN | lM, false},
+
+	"tcp.SrcPort":       {lN | lM, false},
+	"tcp.DstPort":       {lN | lM, false},
+	"tcp.SeqNum":        {lN | lM, false},
+	"tcp.AckNum":        {lN | lM, false},
+	"tcp.HdrLength":     {lN | lM, false},
+	"tcp.Urg":           {lN | lM, false},
+	"tcp.Ack":           {lN | lM, false},
+	"tcp.Psh":           {lN | lM, false},
+	"tcp.Rst":           {lN | lM, false},
+	"tcp.Syn":           {lN | lM, false},
+	"tcp.Fin":           {lN | lM, false},
+	"tcp.Window":        {lN | lM, false},
+	"tcp.Checksum":      {lN | lM, false},
+	"tcp.UrgPtr":        {lN | lM, false},
+	"tcp.PayloadLength": {lN | lM, false},
+
+	"udp.SrcPort":       {lN | lM, false},
+	"udp.DstPort":       {lN | lM, false},
+	"udp.Length":        {lN | lM, false},
+	"udp.Checksum":      {lN | lM, false},
+	"udp.PayloadLength": {lN | lM, false},
+
+	"packet":        {lN | lM, true},
+	"packet16":      {lN | lM, true},
+	"packet32":      {lN | lM, true},
+	"tcp.Payload":   {lN | lM, true},
+	"tcp.Payload16": {lN | lM, true},
+	"tcp.Payload32": {lN | lM, true},
+	"udp.Payload":   {lN | lM, true},
+	"udp.Payload16": {lN | lM, true},
+	"udp.Payload32": {lN | lM, true},
+}
+
+var errProcessLayer = errors.New("processId is only available on the flow, socket and reflect layers")
+
+// Validate checks that every field of expr is a field of the WinDivert filter
+// language, and that the array fields have an index
+func Validate(expr Expr) error {
+	return validate(expr, func(Field, fieldInfo) error { return nil })
+}
+
+// ValidateLayer is Validate which also checks that every field of expr is
+// available on layer, such as processId which is only available on the flow,
+// socket and reflect layers
+func ValidateLayer(expr Expr, layer divert.Layer) error {
+	return validate(expr, func(f Field, info fieldInfo) error {
+		if info.layers&layerBit(layer) == 0 {
+			return fmt.Errorf("Field %v is not available on %v", f, layer)
+		}
+		return nil
+	})
+}
+
+// Compile is ValidateLayer which also compiles the rendered filter with
+// divert.CompileFilter, and returns the object which divert.Open accepts in
+// place of the filter. A filter rejected by WinDivert is reported as a
+// *divert.FilterError.
+func Compile(expr Expr, layer divert.Layer) (string, error) {
+	if err := ValidateLayer(expr, layer); err != nil {
+		return "", err
+	}
+	return divert.CompileFilter(Render(expr), layer)
+}
+
+func validate(expr Expr, check func(Field, fieldInfo) error) error {
+	switch x := expr.(type) {
+	case Field:
+		return validateField(x, check)
+	case *Comparison:
+		if x.Value == "" {
+			return fmt.Errorf("Comparison of %v has no value", x.Field)
+		}
+		return validateField(x.Field, check)
+	case NotExpr:
+		return validate(x.Expr, check)
+	case AndExpr:
+		for _, e := range x {
+			if err := validate(e, check); err != nil {
+				return err
+			}
+		}
+	case OrExpr:
+		for _, e := range x {
+			if err := validate(e, check); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateField(f Field, check func(Field, fieldInfo) error) error {
+	name, index := string(f), ""
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name, index = name[:i], name[i:]
+	}
+
+	info, ok := fields[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("Field %v is unknown", f)
+	case info.array && (len(index) < 3 || index[len(index)-1] != ']'):
+		return fmt.Errorf("Field %v needs an index", f)
+	case !info.array && index != "":
+		return fmt.Errorf("Field %v does not take an index", f)
+	}
+	return check(f, info)
+}
+
+// ByProcess returns an expression matching the packets of the process pid on
+// layer. The network layers know nothing about processes, and a handle on the
+// flow layer has to map the flows of the process to their addresses and ports.
+func ByProcess(pid uint32, layer divert.Layer) (Expr, error) {
+	if fields["processId"].layers&layerBit(layer) == 0 {
+		return nil, errProcessLayer
+	}
+	return Eq("processId", pid), nil
+}
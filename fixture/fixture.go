@@ -0,0 +1,215 @@
+// Package fixture records the packets of a divert handle to fixture files and
+// replays them through packet processing on a MockHandle, without the driver
+// and on any platform, for golden tests of the processing, such as
+//
+//	packets, err := fixture.Read(f)
+//	...
+//	sent, err := fixture.Replay(packets, func(c fixture.PacketConn) fixture.Handler {
+//		return func(p []byte, a *fixture.Address) error {
+//			_, err := c.Send(rewrite(p), a)
+//			return err
+//		}
+//	})
+//	...
+//	if err := fixture.Compare(sent, golden); err != nil {
+//		t.Error(err)
+//	}
+//
+// On Windows, FromHandle runs the same processing on a divert.Handle, and
+// Address.Divert accesses the fields of an address.
+package fixture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// AddressLen is the size of a WINDIVERT_ADDRESS
+const AddressLen = 80
+
+// mtuMax is the MTUMax of divert, the largest packet of a fixture
+const mtuMax = 40 + 0xffff
+
+// Address is the raw WINDIVERT_ADDRESS of a packet, which is the memory of a
+// divert.Address
+type Address [AddressLen]byte
+
+// Packet is a packet of a fixture file with its address and the time it is
+// recorded
+type Packet struct {
+	Time    time.Time
+	Address Address
+	Data    []byte
+}
+
+// Handler processes a packet received by ForEach, and an error stops ForEach
+type Handler func(packet []byte, address *Address) error
+
+// PacketConn receives and sends packets, and is implemented by MockHandle
+// and by the divert.Handle of FromHandle, so that packet processing written
+// against it is tested with a MockHandle
+type PacketConn interface {
+	Recv(buffer []byte, address *Address) (uint, error)
+	Send(buffer []byte, address *Address) (uint, error)
+}
+
+var (
+	// ErrNoData is returned by MockHandle.Recv after the last packet, as
+	// divert.ErrNoData is by a handle which is shut down
+	ErrNoData = errors.New("No packet is left in the fixture")
+
+	errBuffer = errors.New("The packet is larger than the buffer")
+)
+
+// MockHandle is a PacketConn without the driver, which receives a recorded
+// sequence of packets and keeps the packets sent to it
+type MockHandle struct {
+	packets []Packet
+	sent    []Packet
+}
+
+// NewMockHandle returns a MockHandle which receives packets in order
+func NewMockHandle(packets []Packet) *MockHandle {
+	return &MockHandle{packets: packets}
+}
+
+// Recv receives the next packet, and returns ErrNoData after the last packet
+func (m *MockHandle) Recv(buffer []byte, address *Address) (uint, error) {
+	if len(m.packets) == 0 {
+		return 0, ErrNoData
+	}
+	p := m.packets[0]
+	if len(buffer) < len(p.Data) {
+		return 0, errBuffer
+	}
+	m.packets = m.packets[1:]
+
+	*address = p.Address
+	return uint(copy(buffer, p.Data)), nil
+}
+
+// Send keeps a copy of the packet
+func (m *MockHandle) Send(buffer []byte, address *Address) (uint, error) {
+	m.sent = append(m.sent, Packet{
+		Time:    time.Now(),
+		Address: *address,
+		Data:    append([]byte(nil), buffer...),
+	})
+	return uint(len(buffer)), nil
+}
+
+// Sent returns the packets sent
+func (m *MockHandle) Sent() []Packet {
+	return m.sent
+}
+
+// ForEach calls handler for every packet of the mock, until the last packet
+// or handler returns an error. The packet is only valid until handler
+// returns.
+func (m *MockHandle) ForEach(handler Handler) error {
+	buffer := make([]byte, mtuMax)
+	address := new(Address)
+
+	for {
+		n, err := m.Recv(buffer, address)
+		if err != nil {
+			if err == ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		if err := handler(buffer[:n], address); err != nil {
+			return err
+		}
+	}
+}
+
+// record is a line of a fixture file, in which the byte slices are base64 by
+// encoding/json
+type record struct {
+	Time    time.Time `json:"time"`
+	Address []byte    `json:"address"`
+	Data    []byte    `json:"data"`
+}
+
+// Write writes a packet as a line of JSON to w, which is the format of the
+// fixture files read by Read
+func Write(w io.Writer, p Packet) error {
+	b, err := json.Marshal(record{
+		Time:    p.Time,
+		Address: p.Address[:],
+		Data:    p.Data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Read reads the packets of a fixture file written by Write or Record
+func Read(r io.Reader) ([]Packet, error) {
+	packets := []Packet(nil)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 4*mtuMax)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		rec := record{}
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("fixture line %v: %w", line, err)
+		}
+		if len(rec.Address) != AddressLen {
+			return nil, fmt.Errorf("fixture line %v: address is %v bytes", line, len(rec.Address))
+		}
+
+		p := Packet{Time: rec.Time, Data: rec.Data}
+		copy(p.Address[:], rec.Address)
+		packets = append(packets, p)
+	}
+	return packets, s.Err()
+}
+
+// Replay feeds packets through the Handler built by build on a MockHandle,
+// and returns the packets it sends
+func Replay(packets []Packet, build func(PacketConn) Handler) ([]Packet, error) {
+	m := NewMockHandle(packets)
+	err := m.ForEach(build(m))
+	return m.Sent(), err
+}
+
+// Compare compares the packets sent by Replay with the golden packets of
+// want, ignoring the times, and describes the first difference. On Windows,
+// divert.DiffPackets describes the difference of two packets by fields.
+func Compare(got, want []Packet) error {
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if got[i].Address != want[i].Address {
+			return fmt.Errorf("packet %v: address differs", i)
+		}
+		if !bytes.Equal(got[i].Data, want[i].Data) {
+			return fmt.Errorf("packet %v: data differs at byte %v", i, firstDiff(got[i].Data, want[i].Data))
+		}
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("%v packets are sent, want %v", len(got), len(want))
+	}
+	return nil
+}
+
+// firstDiff returns the offset of the first byte which differs in a and b
+func firstDiff(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,94 @@
+package fixture
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func readTestdata(t *testing.T) []Packet {
+	t.Helper()
+
+	f, err := os.Open("testdata/replay.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	packets, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("%v packets are read, want 3", len(packets))
+	}
+	return packets
+}
+
+// TestReplay replays the fixture through a handler which forwards the UDP
+// packets with the TTL decremented and drops the others
+func TestReplay(t *testing.T) {
+	packets := readTestdata(t)
+
+	sent, err := Replay(packets, func(c PacketConn) Handler {
+		return func(p []byte, a *Address) error {
+			if p[9] != 17 {
+				return nil
+			}
+			p[8]--
+			_, err := c.Send(p, a)
+			return err
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Packet{Address: packets[0].Address, Data: append([]byte(nil), packets[0].Data...)}
+	want.Data[8]--
+	if err := Compare(sent, []Packet{want}); err != nil {
+		t.Error(err)
+	}
+
+	if err := Compare(sent, packets[:1]); err == nil {
+		t.Error("Compare misses the rewritten TTL")
+	}
+	if err := Compare(sent, packets); err == nil {
+		t.Error("Compare misses the dropped packets")
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	packets := readTestdata(t)
+
+	b := bytes.Buffer{}
+	for _, p := range packets {
+		if err := Write(&b, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Read(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Compare(got, packets); err != nil {
+		t.Error(err)
+	}
+	for i := range got {
+		if !got[i].Time.Equal(packets[i].Time) {
+			t.Errorf("packet %v: time is %v, want %v", i, got[i].Time, packets[i].Time)
+		}
+	}
+}
+
+func TestRecvShortBuffer(t *testing.T) {
+	m := NewMockHandle(readTestdata(t))
+
+	if _, err := m.Recv(make([]byte, 8), new(Address)); err != errBuffer {
+		t.Fatalf("Recv returns %v, want %v", err, errBuffer)
+	}
+	if n, err := m.Recv(make([]byte, mtuMax), new(Address)); err != nil || n == 0 {
+		t.Fatalf("Recv after a short buffer returns %v, %v", n, err)
+	}
+}
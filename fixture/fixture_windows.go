@@ -0,0 +1,66 @@
+// +build windows
+
+package fixture
+
+import (
+	"io"
+	"time"
+	"unsafe"
+
+	"github.com/imgk/divert-go"
+)
+
+// the Address of a fixture must be the memory of a divert.Address
+var _ [AddressLen - unsafe.Sizeof(divert.Address{})]byte
+var _ [unsafe.Sizeof(divert.Address{}) - AddressLen]byte
+
+// Divert returns the address as a divert.Address, which shares the memory
+func (a *Address) Divert() *divert.Address {
+	return (*divert.Address)(unsafe.Pointer(a))
+}
+
+// Conn is the Recv and Send of divert.Handle and divert.ResilientHandle
+type Conn interface {
+	Recv(buffer []byte, address *divert.Address) (uint, error)
+	Send(buffer []byte, address *divert.Address) (uint, error)
+}
+
+type conn struct {
+	Conn
+}
+
+func (c conn) Recv(buffer []byte, address *Address) (uint, error) {
+	return c.Conn.Recv(buffer, address.Divert())
+}
+
+func (c conn) Send(buffer []byte, address *Address) (uint, error) {
+	return c.Conn.Send(buffer, address.Divert())
+}
+
+// FromHandle returns c as a PacketConn, to run the packet processing tested
+// by Replay on a handle
+func FromHandle(c Conn) PacketConn {
+	return conn{Conn: c}
+}
+
+// Record receives up to n packets from h, or until it is shut down, and
+// writes them to w as a fixture file
+func Record(h *divert.Handle, w io.Writer, n int) error {
+	buffer := make([]byte, divert.MTUMax)
+	address := Address{}
+
+	for i := 0; i < n; i++ {
+		m, err := h.Recv(buffer, address.Divert())
+		if err != nil {
+			if err == divert.ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		if err := Write(w, Packet{Time: time.Now(), Address: address, Data: buffer[:m]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
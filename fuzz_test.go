@@ -0,0 +1,161 @@
+// +build windows
+
+package divert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readCorpus reads the packets of testdata/fuzz/corpus, the seeds of the
+// fuzz targets
+func readCorpus(f *testing.F) [][]byte {
+	files, err := filepath.Glob(filepath.Join("testdata", "fuzz", "corpus", "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	packets := [][]byte{}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		packets = append(packets, b)
+	}
+	return packets
+}
+
+// FuzzParsePacket fails when a parsed packet is not consistent with the
+// buffer, run it with
+//
+//	go test -run '^$' -fuzz FuzzParsePacket
+func FuzzParsePacket(f *testing.F) {
+	for _, packet := range readCorpus(f) {
+		f.Add(packet)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := ParsePacket(data)
+		if err != nil {
+			if p != nil {
+				t.Fatal("packet is not nil on error")
+			}
+			return
+		}
+
+		// every slice must be within the buffer and in the order of the headers
+		end := 0
+		for _, b := range [][]byte{p.IPv4, p.IPv6, p.ICMP, p.ICMPv6, p.TCP, p.UDP, p.Payload} {
+			if len(b) == 0 {
+				continue
+			}
+			off := within(t, data, b)
+			if off < end {
+				t.Fatal("headers overlap")
+			}
+			end = off + len(b)
+		}
+		if (p.IPv4 == nil) == (p.IPv6 == nil) {
+			t.Fatal("packet is not exactly one of IPv4 and IPv6")
+		}
+		transports := 0
+		for _, b := range [][]byte{p.ICMP, p.ICMPv6, p.TCP, p.UDP} {
+			if b != nil {
+				transports++
+			}
+		}
+		if transports > 1 {
+			t.Fatal("packet has more than one transport header")
+		}
+
+		// parsing the same bytes again gives the same result
+		q, err := ParsePacket(append([]byte(nil), data...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p.Payload, q.Payload) || p.Protocol != q.Protocol {
+			t.Fatal("parsing is not deterministic")
+		}
+
+		// a packet with the checksums computed and a new payload is still valid
+		payload := append([]byte(nil), p.Payload...)
+		p.CalcChecksums(ChecksumDefault)
+		if err := q.SetPayload(payload); err != nil {
+			t.Fatal(err)
+		}
+		q.CalcChecksums(ChecksumDefault)
+		if !bytes.Equal(q.Payload, payload) {
+			t.Fatal("payload is not replaced")
+		}
+	})
+}
+
+// within returns the offset of b in data, and fails when b is not a slice of
+// data
+func within(t *testing.T, data, b []byte) int {
+	for off := 0; off+len(b) <= len(data); off++ {
+		if &data[off] == &b[0] {
+			return off
+		}
+	}
+	t.Fatal("slice is out of the buffer")
+	return 0
+}
+
+// FuzzEvalFilter fails when EvalFilter of a filter and of the object compiled
+// by CompileFilter disagree, or when EvalFilter accepts a filter which does
+// not compile. It needs WinDivert.dll, and is skipped without it.
+func FuzzEvalFilter(f *testing.F) {
+	if _, err := CompileFilter("true", LayerNetwork); err != nil {
+		f.Skip(err)
+	}
+
+	packets := readCorpus(f)
+	filters := []string{
+		"true",
+		"false",
+		"outbound and tcp",
+		"tcp.Syn and !tcp.Ack",
+		"udp.DstPort == 53 or udp.SrcPort == 53",
+		"ip.DstAddr == 8.8.8.8",
+		"ipv6 and ipv6.NextHdr == 17",
+		"icmp.Type == 8",
+		"packet[0] == 0x45",
+		"packet32[4] != 0 and packet16[-2b] > 0",
+		"tcp.PayloadLength > 0 and tcp.Payload[0] == 0x47",
+		"(tcp or udp) and not loopback",
+		"ip.TTL >",
+	}
+	for _, filter := range filters {
+		for _, packet := range packets {
+			f.Add(filter, packet, false)
+			f.Add(filter, packet, true)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, filter string, packet []byte, outbound bool) {
+		if strings.IndexByte(filter, 0) >= 0 {
+			return
+		}
+		address := Address{}
+		address.SetLayer(LayerNetwork)
+		address.SetOutbound(outbound)
+
+		object, err := CompileFilter(filter, LayerNetwork)
+		if err != nil {
+			if ok, _ := EvalFilter(filter, packet, &address); ok {
+				t.Fatalf("filter %q does not compile (%v), but matches", filter, err)
+			}
+			return
+		}
+
+		ok1, err1 := EvalFilter(filter, packet, &address)
+		ok2, err2 := EvalFilter(object, packet, &address)
+		if ok1 != ok2 || (err1 == nil) != (err2 == nil) {
+			t.Fatalf("filter %q is %v, %v, and its object is %v, %v", filter, ok1, err1, ok2, err2)
+		}
+	})
+}
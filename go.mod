@@ -1,6 +1,6 @@
 module github.com/imgk/divert-go
 
-go 1.15
+go 1.18
 
 require (
 	golang.org/x/sys v0.0.0-20201116194326-cc9327a14d48
@@ -0,0 +1,50 @@
+// +build windows
+
+package divert
+
+// Handler processes a packet received by ForEach, and an error stops ForEach
+type Handler func(packet []byte, address *Address) error
+
+// Middleware wraps a Handler, and it may log, count, modify or drop a packet
+// before or after calling next
+type Middleware func(next Handler) Handler
+
+// Wrap wraps handler with middleware, and the first middleware is the
+// outermost one which sees a packet first
+func Wrap(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Reinject returns a Handler which sends every packet back to h, which is
+// usually the innermost handler of a pipeline
+func Reinject(h *Handle) Handler {
+	return func(packet []byte, address *Address) error {
+		_, err := h.Send(packet, address)
+		return err
+	}
+}
+
+// ForEach receives packets and calls handler for every packet, until the
+// handle is shut down or handler returns an error. The packet is only valid
+// until handler returns.
+func (h *Handle) ForEach(handler Handler) error {
+	buffer := make([]byte, MTUMax)
+	address := new(Address)
+
+	for {
+		n, err := h.Recv(buffer, address)
+		if err != nil {
+			if err == ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		if err := handler(buffer[:n], address); err != nil {
+			return err
+		}
+	}
+}
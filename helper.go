@@ -0,0 +1,218 @@
+// +build windows,!divert_cgo
+
+package divert
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procsMu = sync.Mutex{}
+	procs   = make(map[string]*proc)
+
+	procSetLastError = modKernel32.NewProc("SetLastError")
+)
+
+// findProc returns the export of WinDivert.dll named name, which is resolved
+// on the first call and cached. A missing export is reported as
+// ErrUnsupportedHelper, as helpers differ between builds of WinDivert.dll.
+func findProc(name string) (*proc, error) {
+	procsMu.Lock()
+	defer procsMu.Unlock()
+
+	p, ok := procs[name]
+	if !ok {
+		if err := load(); err != nil {
+			return nil, err
+		}
+
+		proc, err := winDivert.FindProc(name)
+		if err != nil {
+			proc = nil
+		}
+		procs[name], p = proc, proc
+	}
+	if p == nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedHelper, name)
+	}
+	return p, nil
+}
+
+// uint64Args splits v into the arguments of a UINT64 parameter, which takes
+// two arguments on 32-bit Windows
+func uint64Args(v uint64) []uintptr {
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		return []uintptr{uintptr(v), uintptr(v >> 32)}
+	}
+	return []uintptr{uintptr(v)}
+}
+
+// filterObjectLen is large enough for the object of a filter of the maximum
+// length
+const filterObjectLen = 32 * 1024
+
+// CalcChecksums computes the checksums of packet with
+// WinDivertHelperCalcChecksums, and sets the checksum flags of address when
+// it is not nil
+func CalcChecksums(packet []byte, address *Address, flags uint64) error {
+	if len(packet) == 0 {
+		return errPacketShort
+	}
+
+	proc, err := findProc("WinDivertHelperCalcChecksums")
+	if err != nil {
+		return err
+	}
+
+	args := []uintptr{uintptr(unsafe.Pointer(&packet[0])), uintptr(len(packet)), uintptr(unsafe.Pointer(address))}
+	args = append(args, uint64Args(flags)...)
+
+	ok, _, _ := proc.Call(args...)
+	if ok == 0 {
+		return errPacketHeader
+	}
+	return nil
+}
+
+// DecrementTTL decrements the TTL or the HopLimit of packet with
+// WinDivertHelperDecrementTTL, and returns false when it reaches zero
+func DecrementTTL(packet []byte) (bool, error) {
+	if len(packet) == 0 {
+		return false, errPacketShort
+	}
+
+	proc, err := findProc("WinDivertHelperDecrementTTL")
+	if err != nil {
+		return false, err
+	}
+
+	ok, _, _ := proc.Call(uintptr(unsafe.Pointer(&packet[0])), uintptr(len(packet)))
+	return ok != 0, nil
+}
+
+// HashPacket returns the hash of packet with WinDivertHelperHashPacket
+func HashPacket(packet []byte, seed uint64) (uint64, error) {
+	if len(packet) == 0 {
+		return 0, errPacketShort
+	}
+
+	proc, err := findProc("WinDivertHelperHashPacket")
+	if err != nil {
+		return 0, err
+	}
+
+	args := []uintptr{uintptr(unsafe.Pointer(&packet[0])), uintptr(len(packet))}
+	args = append(args, uint64Args(seed)...)
+
+	r1, r2, _ := proc.Call(args...)
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		return uint64(r1) | uint64(r2)<<32, nil
+	}
+	return uint64(r1), nil
+}
+
+// CompileFilter compiles filter for layer with WinDivertHelperCompileFilter,
+// and the returned object can be passed to Open in place of the filter. A
+// filter which is not valid is reported as a *FilterError.
+func CompileFilter(filter string, layer Layer) (string, error) {
+	filterPtr, err := windows.BytePtrFromString(filter)
+	if err != nil {
+		return "", err
+	}
+
+	object := make([]byte, filterObjectLen)
+	errStr, errPos := (*byte)(nil), uint32(0)
+
+	proc, err := findProc("WinDivertHelperCompileFilter")
+	if err != nil {
+		return "", err
+	}
+
+	ok, _, err := proc.Call(uintptr(unsafe.Pointer(filterPtr)), uintptr(layer), uintptr(unsafe.Pointer(&object[0])), uintptr(len(object)), uintptr(unsafe.Pointer(&errStr)), uintptr(unsafe.Pointer(&errPos)))
+	if ok == 0 {
+		if errStr == nil {
+			return "", Error(err.(windows.Errno))
+		}
+		return "", &FilterError{Message: windows.BytePtrToString(errStr), Position: uint(errPos)}
+	}
+
+	return windows.BytePtrToString(&object[0]), nil
+}
+
+// validateFilter compiles filter for WithValidateFilter, and accepts it when
+// WinDivert.dll does not export WinDivertHelperCompileFilter
+func validateFilter(filter string, layer Layer) error {
+	if _, err := CompileFilter(filter, layer); err != nil && !errors.Is(err, ErrUnsupportedHelper) {
+		return err
+	}
+	return nil
+}
+
+// EvalFilter reports whether packet and address match filter with
+// WinDivertHelperEvalFilter, and packet must be nil for the flow and socket
+// layers
+func EvalFilter(filter string, packet []byte, address *Address) (bool, error) {
+	filterPtr, err := windows.BytePtrFromString(filter)
+	if err != nil {
+		return false, err
+	}
+
+	packetPtr := (*byte)(nil)
+	if len(packet) > 0 {
+		packetPtr = &packet[0]
+	}
+
+	proc, err := findProc("WinDivertHelperEvalFilter")
+	if err != nil {
+		return false, err
+	}
+
+	// the helper only sets the last error when it fails, and returns FALSE
+	// without one for a packet which does not match, so the last error is
+	// cleared first on the thread of the call
+	runtime.LockOSThread()
+	procSetLastError.Call(0)
+	ok, _, err := proc.Call(uintptr(unsafe.Pointer(filterPtr)), uintptr(unsafe.Pointer(packetPtr)), uintptr(len(packet)), uintptr(unsafe.Pointer(address)))
+	runtime.UnlockOSThread()
+	if ok == 0 {
+		if errno := err.(windows.Errno); errno != 0 {
+			return false, Error(errno)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// NormalizeFilter renders filter for layer in the canonical form of
+// WinDivertHelperFormatFilter, so that filters written differently are
+// compared and logged consistently. filter may also be an object returned by
+// CompileFilter. A filter which is not valid is reported as a *FilterError.
+func NormalizeFilter(filter string, layer Layer) (string, error) {
+	filterPtr, err := windows.BytePtrFromString(filter)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := make([]byte, filterObjectLen)
+
+	proc, err := findProc("WinDivertHelperFormatFilter")
+	if err != nil {
+		return "", err
+	}
+
+	ok, _, err := proc.Call(uintptr(unsafe.Pointer(filterPtr)), uintptr(layer), uintptr(unsafe.Pointer(&buffer[0])), uintptr(len(buffer)))
+	if ok == 0 {
+		if _, er := CompileFilter(filter, layer); er != nil {
+			return "", er
+		}
+		return "", Error(err.(windows.Errno))
+	}
+
+	return windows.BytePtrToString(&buffer[0]), nil
+}
@@ -0,0 +1,29 @@
+// +build windows,!divert_cgo
+
+package divert
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// TestUint64Args checks that a UINT64 is split into the low and the high 32
+// bits on 32-bit Windows, which GOARCH=386 tests
+func TestUint64Args(t *testing.T) {
+	for _, v := range []uint64{0, 1, 0xffffffff, 1 << 32, 0x0123456789abcdef, ^uint64(0)} {
+		want := []uintptr{uintptr(v)}
+		if unsafe.Sizeof(uintptr(0)) == 4 {
+			want = []uintptr{uintptr(uint32(v)), uintptr(uint32(v >> 32))}
+		}
+		if got := uint64Args(v); !reflect.DeepEqual(got, want) {
+			t.Errorf("uint64Args(%#x) is %#x, want %#x", v, got, want)
+		}
+	}
+
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		if got := uint64Args(FlagSniff | 1<<40); got[0] != uintptr(FlagSniff) || got[1] != 1<<8 {
+			t.Errorf("flags are split into %#x", got)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// +build windows
+
+package divert
+
+import "strconv"
+
+// ICMPType is the type of an ICMP message
+type ICMPType uint8
+
+// types of ICMP messages, RFC 792
+const (
+	ICMPEchoReply        ICMPType = 0
+	ICMPDestUnreachable  ICMPType = 3
+	ICMPSourceQuench     ICMPType = 4
+	ICMPRedirect         ICMPType = 5
+	ICMPEchoRequest      ICMPType = 8
+	ICMPTimeExceeded     ICMPType = 11
+	ICMPParameterProblem ICMPType = 12
+)
+
+func (t ICMPType) String() string {
+	switch t {
+	case ICMPEchoReply:
+		return "EchoReply"
+	case ICMPDestUnreachable:
+		return "DestUnreachable"
+	case ICMPSourceQuench:
+		return "SourceQuench"
+	case ICMPRedirect:
+		return "Redirect"
+	case ICMPEchoRequest:
+		return "EchoRequest"
+	case ICMPTimeExceeded:
+		return "TimeExceeded"
+	case ICMPParameterProblem:
+		return "ParameterProblem"
+	default:
+		return strconv.Itoa(int(t))
+	}
+}
+
+// IsError reports whether t is the type of an ICMP error message
+func (t ICMPType) IsError() bool {
+	switch t {
+	case ICMPDestUnreachable, ICMPSourceQuench, ICMPRedirect, ICMPTimeExceeded, ICMPParameterProblem:
+		return true
+	}
+	return false
+}
+
+// ICMPv6Type is the type of an ICMPv6 message
+type ICMPv6Type uint8
+
+// types of ICMPv6 messages, RFC 4443
+const (
+	ICMPv6DestUnreachable  ICMPv6Type = 1
+	ICMPv6PacketTooBig     ICMPv6Type = 2
+	ICMPv6TimeExceeded     ICMPv6Type = 3
+	ICMPv6ParameterProblem ICMPv6Type = 4
+	ICMPv6EchoRequest      ICMPv6Type = 128
+	ICMPv6EchoReply        ICMPv6Type = 129
+)
+
+func (t ICMPv6Type) String() string {
+	switch t {
+	case ICMPv6DestUnreachable:
+		return "DestUnreachable"
+	case ICMPv6PacketTooBig:
+		return "PacketTooBig"
+	case ICMPv6TimeExceeded:
+		return "TimeExceeded"
+	case ICMPv6ParameterProblem:
+		return "ParameterProblem"
+	case ICMPv6EchoRequest:
+		return "EchoRequest"
+	case ICMPv6EchoReply:
+		return "EchoReply"
+	default:
+		return strconv.Itoa(int(t))
+	}
+}
+
+// IsError reports whether t is the type of an ICMPv6 error message, which are
+// the types below 128
+func (t ICMPv6Type) IsError() bool {
+	return t < 128
+}
+
+// ICMPCode is the code of an ICMP destination unreachable or time exceeded
+// message
+type ICMPCode uint8
+
+// codes of ICMP destination unreachable, RFC 792 and RFC 1812
+const (
+	ICMPNetUnreachable      ICMPCode = 0
+	ICMPHostUnreachable     ICMPCode = 1
+	ICMPProtocolUnreachable ICMPCode = 2
+	ICMPPortUnreachable     ICMPCode = 3
+	ICMPFragmentationNeeded ICMPCode = 4
+	ICMPAdminProhibited     ICMPCode = 13
+)
+
+// codes of ICMP time exceeded, RFC 792
+const (
+	ICMPTTLExceeded        ICMPCode = 0
+	ICMPReassemblyExceeded ICMPCode = 1
+)
+
+// icmpv6Code maps the codes of ICMP destination unreachable to the codes of
+// ICMPv6 destination unreachable, RFC 4443
+func icmpv6Code(code ICMPCode) uint8 {
+	switch code {
+	case ICMPNetUnreachable:
+		return 0
+	case ICMPAdminProhibited:
+		return 1
+	case ICMPPortUnreachable, ICMPProtocolUnreachable:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// BuildICMPUnreachable builds an ICMP or ICMPv6 destination unreachable
+// message for forPacket, which carries the IP header and the first 8 bytes of
+// the payload of forPacket for IPv4, and as much of forPacket as fits in the
+// minimum MTU for IPv6, with the address to send it.
+//
+// The address sends the message out, which reaches the sender of a packet
+// received inbound. For a packet of a local application received outbound,
+// set the address inbound with the InterfaceIndex of the address of
+// forPacket.
+func BuildICMPUnreachable(forPacket []byte, code ICMPCode) ([]byte, *Address, error) {
+	return buildICMPError(forPacket, ICMPDestUnreachable, uint8(code), ICMPv6DestUnreachable, icmpv6Code(code))
+}
+
+// BuildICMPTimeExceeded builds an ICMP or ICMPv6 time exceeded message for
+// forPacket, as BuildICMPUnreachable does
+func BuildICMPTimeExceeded(forPacket []byte, code ICMPCode) ([]byte, *Address, error) {
+	return buildICMPError(forPacket, ICMPTimeExceeded, uint8(code), ICMPv6TimeExceeded, uint8(code))
+}
+
+func buildICMPError(forPacket []byte, typ ICMPType, code uint8, typ6 ICMPv6Type, code6 uint8) ([]byte, *Address, error) {
+	p, err := ParsePacket(forPacket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// no error is sent for an error or for the fragments after the first one,
+	// RFC 1122 and RFC 4443
+	if p.FragOff != 0 || p.ICMP != nil && p.ICMP.Type().IsError() || p.ICMPv6 != nil && p.ICMPv6.Type().IsError() {
+		return nil, nil, errICMPError
+	}
+
+	address := new(Address)
+	address.SetLayer(LayerNetwork)
+	address.SetEvent(EventNetworkPacket)
+	address.SetOutbound(true)
+
+	b := []byte(nil)
+	if p.IPv4 != nil {
+		n := p.transport + 8
+		if n > p.payload+len(p.Payload) {
+			n = p.payload + len(p.Payload)
+		}
+
+		b = make([]byte, 28, 28+n)
+		b[0] = 0x45
+		ip := IPv4Header(b)
+		ip.SetLength(uint16(28 + n))
+		ip.SetTTL(64)
+		b[9] = byte(ProtoICMP)
+		ip.SetSrcAddr(p.IPv4.DstAddr())
+		ip.SetDstAddr(p.IPv4.SrcAddr())
+		ICMPHeader(b[20:]).SetType(typ)
+		ICMPHeader(b[20:]).SetCode(code)
+		b = append(b, p.Buffer[:n]...)
+	} else {
+		// an ICMPv6 error must not exceed the minimum MTU of 1280 bytes
+		n := p.payload + len(p.Payload)
+		if n > 1280-48 {
+			n = 1280 - 48
+		}
+
+		b = make([]byte, 48, 48+n)
+		b[0] = 0x60
+		ip := IPv6Header(b)
+		ip.SetPayloadLength(uint16(8 + n))
+		b[6] = byte(ProtoICMPv6)
+		ip.SetHopLimit(64)
+		ip.SetSrcAddr(p.IPv6.DstAddr())
+		ip.SetDstAddr(p.IPv6.SrcAddr())
+		ICMPv6Header(b[40:]).SetType(typ6)
+		ICMPv6Header(b[40:]).SetCode(code6)
+		b = append(b, p.Buffer[:n]...)
+
+		address.SetIPv6(true)
+	}
+
+	q, err := ParsePacket(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.CalcChecksums(ChecksumDefault)
+
+	return b, address, nil
+}
@@ -0,0 +1,76 @@
+// +build windows
+
+package divert
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// WinDivert keeps the addresses of the flow and socket layers, and the
+// addresses of its helper functions, in host byte order as 4 UINT32s from the
+// lowest to the highest word, which is the reverse of the bytes of the address
+// in network byte order. An IPv4 address is kept as an IPv4-mapped IPv6
+// address. Zones of IPv6 addresses are not supported by WinDivert.
+
+// reverse16 converts between network byte order and the byte order of
+// WinDivert
+func reverse16(addr [16]byte) [16]byte {
+	for i, j := 0, len(addr)-1; i < j; i, j = i+1, j-1 {
+		addr[i], addr[j] = addr[j], addr[i]
+	}
+	return addr
+}
+
+// ParseIPv6Address parses an IPv6 address into the byte order of WinDivert
+func ParseIPv6Address(s string) ([16]byte, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	if !addr.Is6() {
+		return [16]byte{}, fmt.Errorf("%v is not an IPv6 address", s)
+	}
+	if addr.Zone() != "" {
+		return [16]byte{}, fmt.Errorf("zone of IPv6 address %v is not supported", s)
+	}
+	return reverse16(addr.As16()), nil
+}
+
+// FormatIPv6Address formats an IPv6 address in the byte order of WinDivert
+func FormatIPv6Address(addr [16]byte) string {
+	return netip.AddrFrom16(reverse16(addr)).String()
+}
+
+// ToNetipAddr converts an address in the byte order of WinDivert, such as
+// Flow.LocalAddress, to a netip.Addr, which is an IPv4 address for an
+// IPv4-mapped IPv6 address
+func ToNetipAddr(addr [16]byte) netip.Addr {
+	return netip.AddrFrom16(reverse16(addr)).Unmap()
+}
+
+// FromNetipAddr converts an IPv4 or IPv6 address to the byte order of
+// WinDivert, and the zone of addr is dropped
+func FromNetipAddr(addr netip.Addr) [16]byte {
+	return reverse16(addr.As16())
+}
+
+// NtohIPv6Address converts an IPv6 address in network byte order, such as
+// IPv6Header.SrcAddr, to the byte order of WinDivert, like
+// WinDivertHelperNtohIPv6Address
+func NtohIPv6Address(addr [16]byte) [16]byte {
+	return reverse16(addr)
+}
+
+// HtonIPv6Address converts an IPv6 address in the byte order of WinDivert to
+// network byte order, like WinDivertHelperHtonIPv6Address
+func HtonIPv6Address(addr [16]byte) [16]byte {
+	return reverse16(addr)
+}
+
+// IPv6AddrFromPacket converts an IPv6 address field of a packet, which is in
+// network byte order unlike the addresses of Flow and Socket, to a netip.Addr
+// for comparisons
+func IPv6AddrFromPacket(field [16]byte) netip.Addr {
+	return netip.AddrFrom16(field)
+}
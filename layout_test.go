@@ -0,0 +1,45 @@
+// +build windows
+
+package divert
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// TestLayout checks the sizes of WINDIVERT_ADDRESS, its unions and the
+// WINDIVERT_IOCTL structs of windivert_device.h, which are the same on
+// GOARCH=386 and GOARCH=amd64
+func TestLayout(t *testing.T) {
+	a := Address{}
+	for _, c := range []struct {
+		name string
+		got  uintptr
+		want uintptr
+	}{
+		{"Address", unsafe.Sizeof(Address{}), 80},
+		{"Address.Timestamp", unsafe.Offsetof(a.Timestamp), 0},
+		{"Address.layer", unsafe.Offsetof(a.layer), 8},
+		{"Address.event", unsafe.Offsetof(a.event), 9},
+		{"Address.Flags", unsafe.Offsetof(a.Flags), 10},
+		{"Address.length", unsafe.Offsetof(a.length), 12},
+		{"Address.union", unsafe.Offsetof(a.union), 16},
+		{"Ethernet", unsafe.Sizeof(Ethernet{}), 64},
+		{"Network", unsafe.Sizeof(Network{}), 64},
+		{"Socket", unsafe.Sizeof(Socket{}), 64},
+		{"Flow", unsafe.Sizeof(Flow{}), 64},
+		{"Reflect", unsafe.Sizeof(Reflect{}), 64},
+		{"ioCtl", unsafe.Sizeof(ioCtl{}), 16},
+		{"recv", unsafe.Sizeof(recv{}), 16},
+		{"send", unsafe.Sizeof(send{}), 16},
+		{"initialize", unsafe.Sizeof(initialize{}), 16},
+		{"startup", unsafe.Sizeof(startup{}), 16},
+		{"shutdown", unsafe.Sizeof(shutdown{}), 16},
+		{"getParam", unsafe.Sizeof(getParam{}), 16},
+		{"setParam", unsafe.Sizeof(setParam{}), 16},
+	} {
+		if c.got != c.want {
+			t.Errorf("%v is %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// +build windows
+
+package divert
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fieldSpan is the bytes of a field from the start of its header, of which
+// a flag or a field shorter than a byte takes the byte it is in
+type fieldSpan struct {
+	offset int
+	size   int
+}
+
+var headerFields = map[string]map[string]fieldSpan{
+	"ip": {
+		"HdrLength": {0, 1},
+		"TOS":       {1, 1},
+		"Length":    {2, 2},
+		"Id":        {4, 2},
+		"DF":        {6, 1},
+		"MF":        {6, 1},
+		"FragOff":   {6, 2},
+		"TTL":       {8, 1},
+		"Protocol":  {9, 1},
+		"Checksum":  {10, 2},
+		"SrcAddr":   {12, 4},
+		"DstAddr":   {16, 4},
+	},
+	"ipv6": {
+		"TrafficClass": {0, 2},
+		"FlowLabel":    {1, 3},
+		"Length":       {4, 2},
+		"NextHdr":      {6, 1},
+		"HopLimit":     {7, 1},
+		"SrcAddr":      {8, 16},
+		"DstAddr":      {24, 16},
+	},
+	"icmp": {
+		"Type":     {0, 1},
+		"Code":     {1, 1},
+		"Checksum": {2, 2},
+		"Body":     {4, 4},
+	},
+	"icmpv6": {
+		"Type":     {0, 1},
+		"Code":     {1, 1},
+		"Checksum": {2, 2},
+		"Body":     {4, 4},
+	},
+	"tcp": {
+		"SrcPort":   {0, 2},
+		"DstPort":   {2, 2},
+		"SeqNum":    {4, 4},
+		"AckNum":    {8, 4},
+		"HdrLength": {12, 1},
+		"Urg":       {13, 1},
+		"Ack":       {13, 1},
+		"Psh":       {13, 1},
+		"Rst":       {13, 1},
+		"Syn":       {13, 1},
+		"Fin":       {13, 1},
+		"Window":    {14, 2},
+		"Checksum":  {16, 2},
+		"UrgPtr":    {18, 2},
+	},
+	"udp": {
+		"SrcPort":  {0, 2},
+		"DstPort":  {2, 2},
+		"Length":   {4, 2},
+		"Checksum": {6, 2},
+	},
+}
+
+// FieldLocation returns the offset in packet and the size of the bytes which
+// the filter field inspects, such as tcp.DstPort, packet32[4] or
+// tcp.Payload[-1], so that a filter is explained against the raw bytes. A
+// field whose header is not in packet, which is beyond packet or which is
+// not read from the packet, such as tcp.PayloadLength or outbound, is not
+// ok. A field of a bit, such as tcp.Syn, is the byte it is in.
+func FieldLocation(field string, packet []byte) (offset, size int, ok bool) {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if i := strings.IndexByte(field, '['); i >= 0 && strings.HasSuffix(field, "]") {
+		return indexedLocation(p, field[:i], field[i+1:len(field)-1])
+	}
+
+	i := strings.IndexByte(field, '.')
+	if i < 0 {
+		return 0, 0, false
+	}
+	span, found := headerFields[field[:i]][field[i+1:]]
+	if !found {
+		return 0, 0, false
+	}
+
+	header := []byte(nil)
+	switch field[:i] {
+	case "ip":
+		header = p.IPv4
+	case "ipv6":
+		header = p.IPv6
+	case "icmp":
+		header = p.ICMP
+	case "icmpv6":
+		header = p.ICMPv6
+	case "tcp":
+		header = p.TCP
+	case "udp":
+		header = p.UDP
+	}
+	if header == nil || span.offset+span.size > len(header) {
+		return 0, 0, false
+	}
+	return headerOffset(p, header) + span.offset, span.size, true
+}
+
+// indexedLocation returns the location of packet[i], tcp.Payload16[i] and
+// the like as WinDivertHelperParsePacket does. The index counts elements of
+// the size of the field, or bytes with a b suffix such as packet32[10b], and
+// a negative index is from the end.
+func indexedLocation(p *Packet, name, index string) (int, int, bool) {
+	byteIndex := strings.HasSuffix(index, "b")
+	neg := strings.HasPrefix(index, "-")
+	idx, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSuffix(index, "b"), "-"))
+	if err != nil || idx < 0 || idx > MTUMax {
+		return 0, 0, false
+	}
+
+	size := 1
+	switch {
+	case strings.HasSuffix(name, "16"):
+		name, size = strings.TrimSuffix(name, "16"), 2
+	case strings.HasSuffix(name, "32"):
+		name, size = strings.TrimSuffix(name, "32"), 4
+	}
+	if !byteIndex {
+		idx *= size
+	}
+	// the bounds of the index checked when the filter is compiled
+	if (!neg && idx > 0xffff-size) || (neg && (idx > 0xffff || idx < size)) {
+		return 0, 0, false
+	}
+
+	start, end := 0, p.payload+len(p.Payload)
+	switch name {
+	case "packet":
+	case "tcp.Payload":
+		if p.TCP == nil {
+			return 0, 0, false
+		}
+		start = p.payload
+	case "udp.Payload":
+		if p.UDP == nil {
+			return 0, 0, false
+		}
+		start = p.payload
+	default:
+		return 0, 0, false
+	}
+
+	off := start + idx
+	if neg {
+		off = end - idx
+	}
+	if off < start || off+size > end {
+		return 0, 0, false
+	}
+	return off, size, true
+}
+
+// headerOffset returns the offset of header, which is a slice of p.Buffer
+func headerOffset(p *Packet, header []byte) int {
+	return cap(p.Buffer) - cap(header)
+}
@@ -0,0 +1,37 @@
+// +build windows
+
+package divert
+
+import (
+	"log"
+	"sync"
+)
+
+// Logger logs the warnings of the package, such as a Handle which is
+// collected without Close
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var (
+	loggerMu = sync.Mutex{}
+	logger   = Logger(log.Default())
+)
+
+// SetLogger sets the logger of the package, which is the standard logger by
+// default, and nil discards the warnings
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+func logf(format string, v ...interface{}) {
+	loggerMu.Lock()
+	l := logger
+	loggerMu.Unlock()
+
+	if l != nil {
+		l.Printf(format, v...)
+	}
+}
@@ -0,0 +1,114 @@
+// +build windows
+
+package divert
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// LoopGuard prevents a re-injected packet from being processed again.
+//
+// A packet sent by a handle is not diverted again by the same handle or the
+// handles of higher priorities, but it is diverted again by the handles of
+// lower priorities with overlapping filters, and impostor packets are
+// diverted again by every handle. In both cases a modified packet may be
+// modified again and again. Use FlagSniff when packets are only inspected,
+// keep "not impostor" in the filter when sending impostor packets, or Mark a
+// packet before sending it and pass it through when it is Seen again.
+type LoopGuard struct {
+	mu     sync.Mutex
+	window time.Duration
+	marks  map[uint64]time.Time
+
+	// order are the marks from the oldest to the newest, which Mark prunes
+	// from the front rather than scanning marks
+	order []loopMark
+}
+
+type loopMark struct {
+	key  uint64
+	time time.Time
+}
+
+// NewLoopGuard returns a LoopGuard which remembers a packet for window
+func NewLoopGuard(window time.Duration) *LoopGuard {
+	return &LoopGuard{
+		window: window,
+		marks:  make(map[uint64]time.Time),
+	}
+}
+
+// Mark remembers a packet which is about to be sent
+func (g *LoopGuard) Mark(packet []byte) {
+	key, ok := loopKey(packet)
+	if !ok {
+		return
+	}
+
+	// now is taken under the lock, so that order is sorted by time
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+
+	n := 0
+	for n < len(g.order) && now.Sub(g.order[n].time) > g.window {
+		// a packet marked again or seen has another time or no mark
+		if m := g.order[n]; g.marks[m.key].Equal(m.time) {
+			delete(g.marks, m.key)
+		}
+		n++
+	}
+	// the pruned front is freed when append grows order
+	g.order = g.order[n:]
+
+	g.marks[key] = now
+	g.order = append(g.order, loopMark{key: key, time: now})
+}
+
+// Seen reports whether a received packet is one marked in the window, and
+// forgets it
+func (g *LoopGuard) Seen(packet []byte) bool {
+	key, ok := loopKey(packet)
+	if !ok {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t, ok := g.marks[key]
+	if !ok {
+		return false
+	}
+	delete(g.marks, key)
+	return time.Since(t) <= g.window
+}
+
+// loopKey hashes a packet without the TTL, HopLimit and IPv4 checksum, which
+// change when an impostor packet is injected
+func loopKey(packet []byte) (uint64, bool) {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return 0, false
+	}
+
+	h := fnv.New64a()
+	switch {
+	case p.IPv4 != nil:
+		h.Write(p.IPv4[12:20])
+		b := [3]byte{}
+		binary.BigEndian.PutUint16(b[:], p.IPv4.ID())
+		b[2] = byte(p.Protocol)
+		h.Write(b[:])
+		h.Write(p.Buffer[len(p.IPv4) : p.payload+len(p.Payload)])
+	case p.IPv6 != nil:
+		h.Write(p.IPv6[6:7])
+		h.Write(p.IPv6[8:40])
+		h.Write(p.Buffer[len(p.IPv6) : p.payload+len(p.Payload)])
+	}
+	return h.Sum64(), true
+}
@@ -0,0 +1,117 @@
+// +build windows
+
+package divert
+
+import "sync"
+
+// LoopbackResolver maps the loopback packets of the network layer to the
+// processes at both ends, by keeping the flows of the flow layer which are
+// established on the loopback interface. Both ends of a loopback connection
+// are local, so the flow layer reports a flow for each of them, with the
+// local and the remote endpoints swapped. The flows established before the
+// resolver is created are not known.
+type LoopbackResolver struct {
+	h *Handle
+
+	mu    sync.RWMutex
+	flows map[FlowKey]uint32
+
+	done chan struct{}
+	err  error
+}
+
+// NewLoopbackResolver opens a handle of the flow layer and starts keeping the
+// loopback flows
+func NewLoopbackResolver() (*LoopbackResolver, error) {
+	h, err := Open("loopback", LayerFlow, PriorityDefault, FlagSniff|FlagRecvOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &LoopbackResolver{
+		h:     h,
+		flows: make(map[FlowKey]uint32),
+		done:  make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *LoopbackResolver) run() {
+	defer close(r.done)
+
+	address := Address{}
+	for {
+		if err := r.h.RecvAddress(&address); err != nil {
+			if err != ErrNoData {
+				r.err = err
+			}
+			return
+		}
+
+		flow := address.Flow()
+		key := FlowKey{
+			Protocol: IPProto(flow.Protocol),
+			SrcAddr:  ToNetipAddr(flow.LocalAddress),
+			DstAddr:  ToNetipAddr(flow.RemoteAddress),
+			SrcPort:  flow.LocalPort,
+			DstPort:  flow.RemotePort,
+		}
+
+		r.mu.Lock()
+		switch address.Event() {
+		case EventFlowEstablished:
+			r.flows[key] = flow.ProcessID
+		case EventFlowDeleted:
+			delete(r.flows, key)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Resolve returns the processes which send and receive the packets of key,
+// and false when the flow of either end is not known
+func (r *LoopbackResolver) Resolve(key FlowKey) (src, dst uint32, ok bool) {
+	key.SrcAddr, key.DstAddr = key.SrcAddr.Unmap(), key.DstAddr.Unmap()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	src, ok = r.flows[key]
+	if !ok {
+		return 0, 0, false
+	}
+	dst, ok = r.flows[key.Reverse()]
+	if !ok {
+		return 0, 0, false
+	}
+	return src, dst, true
+}
+
+// ResolvePacket returns the processes of a loopback TCP or UDP packet, as
+// Resolve does
+func (r *LoopbackResolver) ResolvePacket(packet []byte) (src, dst uint32, ok bool) {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return 0, 0, false
+	}
+	key, ok := FlowKeyOf(p)
+	if !ok {
+		return 0, 0, false
+	}
+	return r.Resolve(key)
+}
+
+// Err returns the error which stops keeping the flows, and nil when the
+// resolver is closed. It waits for the resolver to stop.
+func (r *LoopbackResolver) Err() error {
+	<-r.done
+	return r.err
+}
+
+// Close stops keeping the flows and closes the handle
+func (r *LoopbackResolver) Close() error {
+	r.h.Shutdown(ShutdownRecv)
+	<-r.done
+	return r.h.Close()
+}
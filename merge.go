@@ -0,0 +1,59 @@
+// +build windows
+
+package divert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MergeFilters returns a filter which matches a packet when any of filters
+// does, so that one handle replaces a handle for every filter. Every filter
+// is validated with CompileFilter, and they must compile for a common layer.
+//
+// A packet received from the handle of the merged filter is passed back to
+// the consumer of the i-th filter when EvalFilter(filters[i], packet, address)
+// reports true, and more than one consumer may match a packet.
+func MergeFilters(filters []string) (string, error) {
+	all := []Layer{LayerNetwork, LayerNetworkForward, LayerFlow, LayerSocket, LayerReflect}
+
+	// the layers for which all the filters so far compile
+	common := make(map[Layer]bool)
+	for _, layer := range all {
+		common[layer] = true
+	}
+
+	merged := make([]string, 0, len(filters))
+	seen := make(map[string]bool)
+	for i, filter := range filters {
+		filter = strings.TrimSpace(filter)
+
+		ok, first := false, error(nil)
+		for _, layer := range all {
+			if _, err := CompileFilter(filter, layer); err != nil {
+				if first == nil {
+					first = err
+				}
+				delete(common, layer)
+				continue
+			}
+			ok = true
+		}
+		if !ok {
+			return "", fmt.Errorf("filter %v: %w", i, first)
+		}
+		if len(common) == 0 {
+			return "", errMergeLayer
+		}
+
+		if !seen[filter] {
+			seen[filter] = true
+			merged = append(merged, "("+filter+")")
+		}
+	}
+
+	if len(merged) == 0 {
+		return "false", nil
+	}
+	return strings.Join(merged, " or "), nil
+}
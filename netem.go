@@ -0,0 +1,113 @@
+// +build windows
+
+package divert
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// NetemConfig is the network condition simulated by a NetemHandle
+type NetemConfig struct {
+	// Latency is the delay of every packet, and Jitter is the most by which
+	// the delay of a packet differs from it in either direction. A packet
+	// delayed less than the one before it overtakes it, as on a real path.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Loss is the probability of a packet to be dropped, from 0 to 1
+	Loss float64
+}
+
+// NetemHandle diverts the packets of a filter, holds every packet for the
+// latency and the jitter of its config and injects it again, or drops it by
+// the loss of its config, to simulate a slow or lossy network for testing
+type NetemHandle struct {
+	h      *Handle
+	config NetemConfig
+	rand   *rand.Rand
+
+	pending sync.WaitGroup
+	done    chan struct{}
+	err     error
+}
+
+// OpenNetem opens a handle with filter, which diverts the packets rather than
+// sniffing them, and starts delaying them by config
+func OpenNetem(filter string, layer Layer, priority int16, config NetemConfig) (*NetemHandle, error) {
+	h, err := Open(filter, layer, priority, FlagDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &NetemHandle{
+		h:      h,
+		config: config,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n, nil
+}
+
+// delay returns the delay of the next packet, and false when it is lost
+func (n *NetemHandle) delay() (time.Duration, bool) {
+	if n.config.Loss > 0 && n.rand.Float64() < n.config.Loss {
+		return 0, false
+	}
+
+	d := n.config.Latency
+	if n.config.Jitter > 0 {
+		d += time.Duration(n.rand.Int63n(int64(2*n.config.Jitter)+1)) - n.config.Jitter
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+func (n *NetemHandle) run() {
+	defer close(n.done)
+
+	buffer := make([]byte, MTUMax)
+	address := Address{}
+
+	for {
+		m, err := n.h.Recv(buffer, &address)
+		if err != nil {
+			if err != ErrNoData {
+				n.err = err
+			}
+			return
+		}
+
+		d, ok := n.delay()
+		if !ok {
+			continue
+		}
+
+		packet, addr := append([]byte(nil), buffer[:m]...), address
+		n.pending.Add(1)
+		time.AfterFunc(d, func() {
+			defer n.pending.Done()
+			n.h.Send(packet, &addr)
+		})
+	}
+}
+
+// Err returns the error which stops receiving, and nil when the handle is
+// closed. It waits for the handle to stop receiving.
+func (n *NetemHandle) Err() error {
+	<-n.done
+	return n.err
+}
+
+// Close stops diverting packets, injects the packets which are held and
+// closes the handle
+func (n *NetemHandle) Close() error {
+	n.h.Shutdown(ShutdownRecv)
+	<-n.done
+	n.pending.Wait()
+	return n.h.Close()
+}
@@ -0,0 +1,66 @@
+// +build windows
+
+package divert
+
+// Option is an option of Open
+type Option func(*options)
+
+type options struct {
+	validateFilter bool
+	checkPriority  bool
+	autoPriority   bool
+}
+
+// WithValidateFilter makes Open compile the filter with
+// WinDivertHelperCompileFilter before opening the handle, so that a filter
+// which is not valid fails with a *FilterError carrying the message and the
+// position of the error rather than ErrInvalidParameter. The filter is not
+// validated when WinDivert.dll does not export the helper.
+func WithValidateFilter() Option {
+	return func(o *options) {
+		o.validateFilter = true
+	}
+}
+
+// WithPriorityCheck makes Open enumerate the handles open on the system with
+// the reflect layer, and fail with ErrPriorityConflict when one is open on
+// the layer with the priority. The enumeration waits a little for the events
+// of the reflect layer, and it needs the privileges of Open.
+func WithPriorityCheck() Option {
+	return func(o *options) {
+		o.checkPriority = true
+	}
+}
+
+// WithAutoPriority is WithPriorityCheck which opens the handle with the next
+// higher priority which is free rather than failing, and ErrPriorityConflict
+// is only returned when no higher priority is free
+func WithAutoPriority() Option {
+	return func(o *options) {
+		o.checkPriority = true
+		o.autoPriority = true
+	}
+}
+
+// newOptions applies opts to the default options
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// check checks the arguments of Open against the options, and returns the
+// priority to open the handle with
+func (o *options) check(filter string, layer Layer, priority int16) (int16, error) {
+	if o.validateFilter {
+		if err := validateFilter(filter, layer); err != nil {
+			return 0, err
+		}
+	}
+	if o.checkPriority {
+		return checkPriority(layer, priority, o.autoPriority)
+	}
+	return priority, nil
+}
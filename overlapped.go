@@ -0,0 +1,244 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Overlapped is an outstanding operation started by RecvAsync or SendAsync,
+// so that several operations can be in flight on one handle at the same time.
+// It must not be reused before the operation completes, and its buffer and
+// addresses must not be touched until then.
+type Overlapped struct {
+	// windows.Overlapped must be the first field, so that the overlapped
+	// dequeued from a completion port is converted back by OverlappedOf
+	windows.Overlapped
+
+	code    ctlCode
+	recv    recv
+	send    send
+	addrLen uint32
+	buffer  []byte
+	address []Address
+
+	// rearm is set for the receives started by Reactor.Start, until ctx of
+	// Reactor.StartContext is done
+	rearm bool
+	ctx   context.Context
+}
+
+// NewOverlapped returns an Overlapped with an event, which is signaled when
+// the operation completes
+func NewOverlapped() (*Overlapped, error) {
+	event, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Overlapped{}
+	o.HEvent = event
+	return o, nil
+}
+
+// OverlappedOf returns the Overlapped of an overlapped dequeued from a
+// completion port by GetQueuedCompletionStatus
+func OverlappedOf(overlapped *windows.Overlapped) *Overlapped {
+	return (*Overlapped)(unsafe.Pointer(overlapped))
+}
+
+// Buffer returns the buffer of the last operation
+func (o *Overlapped) Buffer() []byte {
+	return o.buffer
+}
+
+// Addresses returns the addresses of the last operation, which are the
+// received addresses after a RecvAsync completes
+func (o *Overlapped) Addresses() []Address {
+	return o.address[:uint(o.addrLen)/uint(unsafe.Sizeof(Address{}))]
+}
+
+// Close closes the event of the Overlapped
+func (o *Overlapped) Close() error {
+	return windows.CloseHandle(o.HEvent)
+}
+
+// RecvAsync starts receiving up to len(address) packets into buffer, and
+// returns without waiting for the packets. The operation completes by the
+// event of o, GetOverlappedResult or the completion port of the handle.
+func (h *Handle) RecvAsync(buffer []byte, address []Address, o *Overlapped) error {
+	if h.handle() == windows.InvalidHandle {
+		return ErrClosed
+	}
+
+	if h.flags&FlagSendOnly != 0 {
+		return ErrWrongDirection
+	}
+
+	if len(address) < 1 || len(address) > BatchMax {
+		return errBatchSize
+	}
+
+	o.buffer, o.address = buffer, address
+	o.addrLen = uint32(len(address)) * uint32(unsafe.Sizeof(Address{}))
+	o.recv = recv{
+		Addr:       uint64(uintptr(unsafe.Pointer(&address[0]))),
+		AddrLenPtr: uint64(uintptr(unsafe.Pointer(&o.addrLen))),
+	}
+
+	return startAsync(h.handle(), ioCtlRecv, unsafe.Pointer(&o.recv), buffer, o)
+}
+
+// SendAsync starts sending len(address) packets in buffer, and returns
+// without waiting for the driver, as RecvAsync does
+func (h *Handle) SendAsync(buffer []byte, address []Address, o *Overlapped) error {
+	if h.handle() == windows.InvalidHandle {
+		return ErrClosed
+	}
+
+	if h.flags&FlagRecvOnly != 0 {
+		return ErrWrongDirection
+	}
+
+	if len(address) < 1 || len(address) > BatchMax {
+		return errBatchSize
+	}
+
+	o.buffer, o.address = buffer, address
+	o.addrLen = uint32(len(address)) * uint32(unsafe.Sizeof(Address{}))
+	o.send = send{
+		Addr:    uint64(uintptr(unsafe.Pointer(&address[0]))),
+		AddrLen: uint64(o.addrLen),
+	}
+
+	return startAsync(h.handle(), ioCtlSend, unsafe.Pointer(&o.send), buffer, o)
+}
+
+func startAsync(h windows.Handle, code ctlCode, ioctl unsafe.Pointer, buffer []byte, o *Overlapped) error {
+	if !code.valid() {
+		return ErrInvalidFunction
+	}
+
+	o.code = code
+	err := windows.DeviceIoControl(h, uint32(code), (*byte)(ioctl), uint32(unsafe.Sizeof(ioCtl{})), bufferPtr(buffer), uint32(len(buffer)), nil, &o.Overlapped)
+	if err != nil && err != windows.ERROR_IO_PENDING {
+		return Error(err.(windows.Errno))
+	}
+
+	return nil
+}
+
+// GetOverlappedResult returns the number of bytes of an operation started by
+// RecvAsync or SendAsync, and ErrIOIncomplete when wait is false and the
+// operation is still outstanding
+func (h *Handle) GetOverlappedResult(o *Overlapped, wait bool) (uint, error) {
+	if h.handle() == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
+	iolen := uint32(0)
+	if err := windows.GetOverlappedResult(h.handle(), &o.Overlapped, &iolen, wait); err != nil {
+		return uint(iolen), Error(err.(windows.Errno))
+	}
+
+	return uint(iolen), nil
+}
+
+// AssociateCompletionPort associates the handle with a completion port, which
+// is created when port is 0, and returns the port. Completions of RecvAsync
+// and SendAsync are queued to the port with key, while the other operations
+// of the handle keep waiting on their own events. It fails while a Recv,
+// Send or RecvStart is pending, as it changes the events they wait on.
+func (h *Handle) AssociateCompletionPort(port windows.Handle, key uint32) (windows.Handle, error) {
+	hd := h.handle()
+	if hd == windows.InvalidHandle {
+		return 0, ErrClosed
+	}
+
+	if h.pending != nil {
+		return 0, errRecvPending
+	}
+	for _, o := range []*windows.Overlapped{&h.rOverlapped, &h.wOverlapped} {
+		iolen := uint32(0)
+		if windows.GetOverlappedResult(hd, o, &iolen, false) == windows.ERROR_IO_INCOMPLETE {
+			return 0, errIOPending
+		}
+	}
+
+	// an event with the low-order bit set keeps the completion from being
+	// queued to the completion port, and the bit is kept when the event is
+	// created later
+	orEvent(&h.rOverlapped.HEvent, 1)
+	orEvent(&h.wOverlapped.HEvent, 1)
+
+	return windows.CreateIoCompletionPort(hd, port, key, 0)
+}
+
+// orEvent sets bits in the event of an overlapped, atomically as the event
+// may be created by readOverlapped or writeOverlapped at the same time
+func orEvent(event *windows.Handle, bits windows.Handle) {
+	p := (*uintptr)(unsafe.Pointer(event))
+	for {
+		old := atomic.LoadUintptr(p)
+		if atomic.CompareAndSwapUintptr(p, old, old|uintptr(bits)) {
+			return
+		}
+	}
+}
+
+// ReadEvent returns the event of the handle which is signaled when a receive
+// started by RecvStart completes, so that the handle is waited together with
+// other objects by WaitForMultipleObjects. The protocol is
+//
+//	h.RecvStart(buffer, address)
+//	// wait for h.ReadEvent() among other objects
+//	n, m, err := h.RecvResult()
+//
+// and RecvResult returns ErrIOIncomplete when the receive is still
+// outstanding. The event is auto-reset and is shared with Recv and RecvEx,
+// which must not be called while a receive started by RecvStart is pending.
+func (h *Handle) ReadEvent() windows.Handle {
+	return h.readOverlapped().HEvent &^ 1
+}
+
+// RecvStart starts receiving up to len(address) packets into buffer without
+// waiting, and the receive completes with RecvResult. Only one receive
+// started by RecvStart can be pending at a time.
+func (h *Handle) RecvStart(buffer []byte, address []Address) error {
+	if h.pending != nil {
+		return errRecvPending
+	}
+
+	o := &Overlapped{}
+	o.HEvent = h.readOverlapped().HEvent
+	if err := h.RecvAsync(buffer, address, o); err != nil {
+		return err
+	}
+	h.pending = o
+
+	return nil
+}
+
+// RecvResult returns the number of bytes and addresses of the receive started
+// by RecvStart without waiting, and ErrIOIncomplete when it is still pending
+func (h *Handle) RecvResult() (uint, uint, error) {
+	o := h.pending
+	if o == nil {
+		return 0, 0, errRecvNotPending
+	}
+
+	n, err := h.GetOverlappedResult(o, false)
+	if err == ErrIOIncomplete {
+		return 0, 0, err
+	}
+	h.pending = nil
+	if err != nil {
+		return n, 0, err
+	}
+
+	return n, uint(len(o.Addresses())), nil
+}
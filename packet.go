@@ -0,0 +1,870 @@
+// +build windows
+
+package divert
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+type IPProto uint8
+
+const (
+	ProtoICMP   IPProto = 1
+	ProtoTCP    IPProto = 6
+	ProtoUDP    IPProto = 17
+	ProtoGRE    IPProto = 47
+	ProtoESP    IPProto = 50
+	ProtoAH     IPProto = 51
+	ProtoICMPv6 IPProto = 58
+)
+
+// IPv6 extension headers skipped by ParsePacket, which also skips ProtoAH
+// among them
+const (
+	protoHopOpts  IPProto = 0
+	protoRouting  IPProto = 43
+	protoFragment IPProto = 44
+	protoDstOpts  IPProto = 60
+	protoMH       IPProto = 135
+)
+
+func (p IPProto) String() string {
+	switch p {
+	case ProtoICMP:
+		return "ICMP"
+	case ProtoTCP:
+		return "TCP"
+	case ProtoUDP:
+		return "UDP"
+	case ProtoGRE:
+		return "GRE"
+	case ProtoESP:
+		return "ESP"
+	case ProtoAH:
+		return "AH"
+	case ProtoICMPv6:
+		return "ICMPv6"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// IPv4Header is an IPv4 header in network byte order
+type IPv4Header []byte
+
+func (h IPv4Header) Version() uint8 {
+	return h[0] >> 4
+}
+
+func (h IPv4Header) HdrLength() int {
+	return int(h[0]&0x0f) << 2
+}
+
+func (h IPv4Header) Length() uint16 {
+	return binary.BigEndian.Uint16(h[2:])
+}
+
+func (h IPv4Header) SetLength(n uint16) {
+	binary.BigEndian.PutUint16(h[2:], n)
+}
+
+func (h IPv4Header) ID() uint16 {
+	return binary.BigEndian.Uint16(h[4:])
+}
+
+// FragOff returns the fragment offset in units of 8 bytes
+func (h IPv4Header) FragOff() uint16 {
+	return binary.BigEndian.Uint16(h[6:]) & 0x1fff
+}
+
+func (h IPv4Header) MF() bool {
+	return h[6]&0x20 != 0
+}
+
+func (h IPv4Header) DF() bool {
+	return h[6]&0x40 != 0
+}
+
+func (h IPv4Header) TTL() uint8 {
+	return h[8]
+}
+
+func (h IPv4Header) SetTTL(ttl uint8) {
+	h[8] = ttl
+}
+
+func (h IPv4Header) Protocol() IPProto {
+	return IPProto(h[9])
+}
+
+func (h IPv4Header) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[10:])
+}
+
+func (h IPv4Header) SetChecksum(sum uint16) {
+	binary.BigEndian.PutUint16(h[10:], sum)
+}
+
+func (h IPv4Header) SrcAddr() (addr [4]byte) {
+	copy(addr[:], h[12:16])
+	return
+}
+
+func (h IPv4Header) SetSrcAddr(addr [4]byte) {
+	copy(h[12:16], addr[:])
+}
+
+func (h IPv4Header) DstAddr() (addr [4]byte) {
+	copy(addr[:], h[16:20])
+	return
+}
+
+func (h IPv4Header) SetDstAddr(addr [4]byte) {
+	copy(h[16:20], addr[:])
+}
+
+// IPv6Header is an IPv6 header in network byte order
+type IPv6Header []byte
+
+func (h IPv6Header) Version() uint8 {
+	return h[0] >> 4
+}
+
+func (h IPv6Header) PayloadLength() uint16 {
+	return binary.BigEndian.Uint16(h[4:])
+}
+
+func (h IPv6Header) SetPayloadLength(n uint16) {
+	binary.BigEndian.PutUint16(h[4:], n)
+}
+
+func (h IPv6Header) NextHdr() IPProto {
+	return IPProto(h[6])
+}
+
+func (h IPv6Header) HopLimit() uint8 {
+	return h[7]
+}
+
+func (h IPv6Header) SetHopLimit(n uint8) {
+	h[7] = n
+}
+
+func (h IPv6Header) SrcAddr() (addr [16]byte) {
+	copy(addr[:], h[8:24])
+	return
+}
+
+func (h IPv6Header) SetSrcAddr(addr [16]byte) {
+	copy(h[8:24], addr[:])
+}
+
+func (h IPv6Header) DstAddr() (addr [16]byte) {
+	copy(addr[:], h[24:40])
+	return
+}
+
+func (h IPv6Header) SetDstAddr(addr [16]byte) {
+	copy(h[24:40], addr[:])
+}
+
+// ICMPHeader is an ICMP header in network byte order
+type ICMPHeader []byte
+
+func (h ICMPHeader) Type() ICMPType {
+	return ICMPType(h[0])
+}
+
+func (h ICMPHeader) SetType(typ ICMPType) {
+	h[0] = byte(typ)
+}
+
+func (h ICMPHeader) Code() uint8 {
+	return h[1]
+}
+
+func (h ICMPHeader) SetCode(code uint8) {
+	h[1] = code
+}
+
+func (h ICMPHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[2:])
+}
+
+func (h ICMPHeader) SetChecksum(sum uint16) {
+	binary.BigEndian.PutUint16(h[2:], sum)
+}
+
+// Body returns the 4 bytes of the header after the checksum
+func (h ICMPHeader) Body() uint32 {
+	return binary.BigEndian.Uint32(h[4:])
+}
+
+// ID returns the identifier of an echo request or reply
+func (h ICMPHeader) ID() uint16 {
+	return binary.BigEndian.Uint16(h[4:])
+}
+
+// Seq returns the sequence number of an echo request or reply
+func (h ICMPHeader) Seq() uint16 {
+	return binary.BigEndian.Uint16(h[6:])
+}
+
+// ICMPv6Header is an ICMPv6 header in network byte order
+type ICMPv6Header []byte
+
+func (h ICMPv6Header) Type() ICMPv6Type {
+	return ICMPv6Type(h[0])
+}
+
+func (h ICMPv6Header) SetType(typ ICMPv6Type) {
+	h[0] = byte(typ)
+}
+
+func (h ICMPv6Header) Code() uint8 {
+	return h[1]
+}
+
+func (h ICMPv6Header) SetCode(code uint8) {
+	h[1] = code
+}
+
+func (h ICMPv6Header) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[2:])
+}
+
+func (h ICMPv6Header) SetChecksum(sum uint16) {
+	binary.BigEndian.PutUint16(h[2:], sum)
+}
+
+// Body returns the 4 bytes of the header after the checksum
+func (h ICMPv6Header) Body() uint32 {
+	return binary.BigEndian.Uint32(h[4:])
+}
+
+// ID returns the identifier of an echo request or reply
+func (h ICMPv6Header) ID() uint16 {
+	return binary.BigEndian.Uint16(h[4:])
+}
+
+// Seq returns the sequence number of an echo request or reply
+func (h ICMPv6Header) Seq() uint16 {
+	return binary.BigEndian.Uint16(h[6:])
+}
+
+// TCPFlags are the flags of a TCP header
+type TCPFlags uint8
+
+const (
+	TCPFin TCPFlags = 1 << iota
+	TCPSyn
+	TCPRst
+	TCPPsh
+	TCPAck
+	TCPUrg
+)
+
+// TCPHeader is a TCP header in network byte order
+type TCPHeader []byte
+
+func (h TCPHeader) SrcPort() uint16 {
+	return binary.BigEndian.Uint16(h[0:])
+}
+
+func (h TCPHeader) SetSrcPort(port uint16) {
+	binary.BigEndian.PutUint16(h[0:], port)
+}
+
+func (h TCPHeader) DstPort() uint16 {
+	return binary.BigEndian.Uint16(h[2:])
+}
+
+func (h TCPHeader) SetDstPort(port uint16) {
+	binary.BigEndian.PutUint16(h[2:], port)
+}
+
+func (h TCPHeader) SeqNum() uint32 {
+	return binary.BigEndian.Uint32(h[4:])
+}
+
+func (h TCPHeader) SetSeqNum(n uint32) {
+	binary.BigEndian.PutUint32(h[4:], n)
+}
+
+func (h TCPHeader) AckNum() uint32 {
+	return binary.BigEndian.Uint32(h[8:])
+}
+
+func (h TCPHeader) SetAckNum(n uint32) {
+	binary.BigEndian.PutUint32(h[8:], n)
+}
+
+func (h TCPHeader) HdrLength() int {
+	return int(h[12]>>4) << 2
+}
+
+func (h TCPHeader) Fin() bool {
+	return h[13]&0x01 != 0
+}
+
+func (h TCPHeader) Syn() bool {
+	return h[13]&0x02 != 0
+}
+
+func (h TCPHeader) Rst() bool {
+	return h[13]&0x04 != 0
+}
+
+func (h TCPHeader) Psh() bool {
+	return h[13]&0x08 != 0
+}
+
+func (h TCPHeader) Ack() bool {
+	return h[13]&0x10 != 0
+}
+
+func (h TCPHeader) Urg() bool {
+	return h[13]&0x20 != 0
+}
+
+// Flags returns the FIN, SYN, RST, PSH, ACK and URG flags
+func (h TCPHeader) Flags() TCPFlags {
+	return TCPFlags(h[13] & 0x3f)
+}
+
+func (h TCPHeader) SetFlags(flags TCPFlags) {
+	h[13] = h[13]&^0x3f | byte(flags)&0x3f
+}
+
+func (h TCPHeader) Window() uint16 {
+	return binary.BigEndian.Uint16(h[14:])
+}
+
+func (h TCPHeader) SetWindow(n uint16) {
+	binary.BigEndian.PutUint16(h[14:], n)
+}
+
+func (h TCPHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[16:])
+}
+
+func (h TCPHeader) SetChecksum(sum uint16) {
+	binary.BigEndian.PutUint16(h[16:], sum)
+}
+
+func (h TCPHeader) UrgPtr() uint16 {
+	return binary.BigEndian.Uint16(h[18:])
+}
+
+// UDPHeader is a UDP header in network byte order
+type UDPHeader []byte
+
+func (h UDPHeader) SrcPort() uint16 {
+	return binary.BigEndian.Uint16(h[0:])
+}
+
+func (h UDPHeader) SetSrcPort(port uint16) {
+	binary.BigEndian.PutUint16(h[0:], port)
+}
+
+func (h UDPHeader) DstPort() uint16 {
+	return binary.BigEndian.Uint16(h[2:])
+}
+
+func (h UDPHeader) SetDstPort(port uint16) {
+	binary.BigEndian.PutUint16(h[2:], port)
+}
+
+func (h UDPHeader) Length() uint16 {
+	return binary.BigEndian.Uint16(h[4:])
+}
+
+func (h UDPHeader) SetLength(n uint16) {
+	binary.BigEndian.PutUint16(h[4:], n)
+}
+
+func (h UDPHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[6:])
+}
+
+func (h UDPHeader) SetChecksum(sum uint16) {
+	binary.BigEndian.PutUint16(h[6:], sum)
+}
+
+// GREHeader is a GRE header in network byte order, with the optional
+// checksum, offset, key, sequence number and routing fields
+type GREHeader []byte
+
+const (
+	greChecksum = 0x80
+	greRouting  = 0x40
+	greKey      = 0x20
+	greSeq      = 0x10
+)
+
+// HdrLength returns the length of the header, which depends on the fields
+// present. The routing field of RFC 1701 is a list of source route entries
+// ended by an entry of length 0, and a list which does not end in h makes
+// the length exceed h.
+func (h GREHeader) HdrLength() int {
+	n := 4
+	if h[0]&(greChecksum|greRouting) != 0 {
+		n += 4
+	}
+	for _, f := range []byte{greKey, greSeq} {
+		if h[0]&f != 0 {
+			n += 4
+		}
+	}
+	if h[0]&greRouting == 0 {
+		return n
+	}
+	for {
+		if n+4 > len(h) {
+			return n + 4
+		}
+		length := int(h[n+3])
+		n += 4 + length
+		if length == 0 {
+			return n
+		}
+	}
+}
+
+func (h GREHeader) Version() uint8 {
+	return h[1] & 0x07
+}
+
+// ProtocolType returns the EtherType of the encapsulated packet, such as
+// 0x0800 for IPv4
+func (h GREHeader) ProtocolType() uint16 {
+	return binary.BigEndian.Uint16(h[2:])
+}
+
+// Key returns the key, and false when it is not present
+func (h GREHeader) Key() (uint32, bool) {
+	if h[0]&greKey == 0 {
+		return 0, false
+	}
+	off := 4
+	if h[0]&(greChecksum|greRouting) != 0 {
+		off += 4
+	}
+	return binary.BigEndian.Uint32(h[off:]), true
+}
+
+// ESPHeader is the cleartext part of an ESP header in network byte order
+type ESPHeader []byte
+
+func (h ESPHeader) SPI() uint32 {
+	return binary.BigEndian.Uint32(h[0:])
+}
+
+func (h ESPHeader) Seq() uint32 {
+	return binary.BigEndian.Uint32(h[4:])
+}
+
+// AHHeader is an authentication header in network byte order
+type AHHeader []byte
+
+func (h AHHeader) NextHdr() IPProto {
+	return IPProto(h[0])
+}
+
+func (h AHHeader) HdrLength() int {
+	return (int(h[1]) + 2) * 4
+}
+
+func (h AHHeader) SPI() uint32 {
+	return binary.BigEndian.Uint32(h[4:])
+}
+
+func (h AHHeader) Seq() uint32 {
+	return binary.BigEndian.Uint32(h[8:])
+}
+
+// ICV returns the integrity check value
+func (h AHHeader) ICV() []byte {
+	return h[12:]
+}
+
+// Packet is a parsed IPv4 or IPv6 packet. The headers and the payload are
+// slices of Buffer, and only one of IPv4 and IPv6, and at most one of ICMP,
+// ICMPv6, TCP and UDP are not nil. Parsing follows WinDivertHelperParsePacket:
+// IPv6 extension headers are skipped, a fragment with a non-zero offset has
+// no transport header, and a transport header which is not valid is left in
+// the payload.
+type Packet struct {
+	Buffer   []byte
+	IPv4     IPv4Header
+	IPv6     IPv6Header
+	Protocol IPProto
+	ICMP     ICMPHeader
+	ICMPv6   ICMPv6Header
+	TCP      TCPHeader
+	UDP      UDPHeader
+	Payload  []byte
+
+	// GRE and ESP are the headers of tunnels, and Payload is the packet in
+	// the GRE tunnel or the encrypted data of ESP. AH is the authentication
+	// header of an IPv6 packet, which is skipped to the header it protects
+	// as an extension header, and Protocol is the protocol of that header.
+	// WinDivertHelperParsePacket does not skip the AH of an IPv4 packet,
+	// whose Protocol is ProtoAH and whose AH is in the payload.
+	GRE GREHeader
+	ESP ESPHeader
+	AH  AHHeader
+
+	// Fragment reports whether the packet is a fragment, of which FragOff is
+	// the offset in units of 8 bytes and MF is the more fragments flag
+	Fragment bool
+	FragOff  uint16
+	MF       bool
+
+	// Truncated reports whether Buffer is shorter than the length of the
+	// packet in its IP header
+	Truncated bool
+
+	transport int
+	payload   int
+	dirty     bool
+}
+
+// ParsePacket parses the IPv4 or IPv6 packet at the start of buffer
+func ParsePacket(buffer []byte) (*Packet, error) {
+	p := &Packet{Buffer: buffer}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParsePacketInto is ParsePacket which parses into p, so that a Packet is
+// reused for every received packet without an allocation. Together with
+// editing Payload in place, MarkDirty or SetPayload with a payload which
+// fits the capacity of Buffer, and SendPacket, it makes an edit pipeline
+// which does not allocate:
+//
+//	p := divert.Packet{}
+//	for {
+//		n, err := h.Recv(buffer, &address)
+//		...
+//		if err := divert.ParsePacketInto(&p, buffer[:n]); err != nil {
+//			...
+//		}
+//		copy(p.Payload, replacement)
+//		p.MarkDirty()
+//		if _, err := h.SendPacket(&p, &address); err != nil {
+//			...
+//		}
+//	}
+func ParsePacketInto(p *Packet, buffer []byte) error {
+	p.Buffer, p.dirty = buffer, false
+	return p.parse()
+}
+
+func (p *Packet) parse() error {
+	*p = Packet{Buffer: p.Buffer, dirty: p.dirty}
+
+	b := p.Buffer
+	if len(b) < 20 {
+		return errPacketShort
+	}
+
+	// every length read from the headers is checked against the buffer, and
+	// a packet truncated by the buffer is clamped to it
+	off := 0
+	switch b[0] >> 4 {
+	case 4:
+		hdr := IPv4Header(b)
+		n := hdr.HdrLength()
+		if n < 20 || int(hdr.Length()) < n {
+			return errPacketHeader
+		}
+		if n > len(b) {
+			return errPacketShort
+		}
+		p.Truncated = int(hdr.Length()) > len(b)
+		if !p.Truncated {
+			b = b[:hdr.Length()]
+		}
+		p.IPv4 = IPv4Header(b[:n])
+		p.Protocol = p.IPv4.Protocol()
+		p.FragOff, p.MF = p.IPv4.FragOff(), p.IPv4.MF()
+		p.Fragment = p.FragOff != 0 || p.MF
+		off = n
+	case 6:
+		if len(b) < 40 {
+			return errPacketShort
+		}
+		hdr := IPv6Header(b)
+		n := 40 + int(hdr.PayloadLength())
+		p.Truncated = n > len(b)
+		if !p.Truncated {
+			b = b[:n]
+		}
+		p.IPv6 = IPv6Header(b[:40])
+		p.Protocol = p.IPv6.NextHdr()
+		off = 40
+
+		for p.FragOff == 0 && len(b)-off >= 2 {
+			n := int(b[off+1])
+			switch p.Protocol {
+			case protoFragment:
+				n = 8
+				if p.Fragment || len(b)-off < n {
+					n = -1
+					break
+				}
+				p.FragOff = binary.BigEndian.Uint16(b[off+2:]) >> 3
+				p.MF = b[off+3]&0x01 != 0
+				p.Fragment = true
+			case ProtoAH:
+				n = (n + 2) * 4
+				if len(b)-off >= n && n >= 12 {
+					p.AH = AHHeader(b[off : off+n])
+				}
+			case protoHopOpts, protoDstOpts, protoRouting, protoMH:
+				n = (n + 1) * 8
+			default:
+				n = -1
+			}
+			if n < 0 || len(b)-off < n {
+				break
+			}
+			p.Protocol = IPProto(b[off])
+			off += n
+		}
+	default:
+		return errPacketVersion
+	}
+
+	p.transport = off
+	if p.FragOff == 0 {
+		switch p.Protocol {
+		case ProtoTCP:
+			if len(b)-off < 20 {
+				break
+			}
+			n := TCPHeader(b[off:]).HdrLength()
+			if n < 20 {
+				break
+			}
+			if n > len(b)-off {
+				n = len(b) - off
+			}
+			p.TCP = TCPHeader(b[off : off+n])
+			off += n
+		case ProtoUDP:
+			if len(b)-off < 8 {
+				break
+			}
+			p.UDP = UDPHeader(b[off : off+8])
+			off += 8
+		case ProtoICMP:
+			if p.IPv4 == nil || len(b)-off < 8 {
+				break
+			}
+			p.ICMP = ICMPHeader(b[off : off+8])
+			off += 8
+		case ProtoICMPv6:
+			if p.IPv6 == nil || len(b)-off < 8 {
+				break
+			}
+			p.ICMPv6 = ICMPv6Header(b[off : off+8])
+			off += 8
+		case ProtoESP:
+			if len(b)-off < 8 {
+				break
+			}
+			p.ESP = ESPHeader(b[off : off+8])
+			off += 8
+		case ProtoGRE:
+			if len(b)-off < 4 {
+				break
+			}
+			n := GREHeader(b[off:]).HdrLength()
+			if n > len(b)-off {
+				break
+			}
+			p.GRE = GREHeader(b[off : off+n])
+			off += n
+		}
+	}
+
+	p.Payload = b[off:len(b):len(b)]
+	p.payload = off
+	return nil
+}
+
+// PayloadOffset returns the offset of Payload in Buffer, which is after the
+// headers known to ParsePacket and is the raw payload of the other protocols
+func (p *Packet) PayloadOffset() int {
+	return p.payload
+}
+
+// Next returns the bytes of Buffer after the packet, such as the next packets
+// received by RecvEx
+func (p *Packet) Next() []byte {
+	return p.Buffer[p.payload+len(p.Payload):]
+}
+
+// PayloadSlice returns n bytes of the payload starting at off. It never reads
+// past the payload, and the returned slice is shorter than n or nil when the
+// payload is not long enough.
+func (p *Packet) PayloadSlice(off, n int) []byte {
+	if off < 0 || n < 0 || off >= len(p.Payload) {
+		return nil
+	}
+	if n > len(p.Payload)-off {
+		n = len(p.Payload) - off
+	}
+	return p.Payload[off : off+n : off+n]
+}
+
+// CopyPayload returns a copy of the payload, which is still valid when
+// Buffer is reused for receiving another packet
+func (p *Packet) CopyPayload() []byte {
+	return append([]byte(nil), p.Payload...)
+}
+
+// CopyFull returns a copy of the packet from the IP header to the end of the
+// payload, as CopyPayload does for the payload
+func (p *Packet) CopyFull() []byte {
+	return append([]byte(nil), p.Buffer[:p.payload+len(p.Payload)]...)
+}
+
+// Dirty reports whether the packet is modified by SetPayload or MarkDirty and
+// the checksums need to be computed again by CalcChecksums
+func (p *Packet) Dirty() bool {
+	return p.dirty
+}
+
+// MarkDirty marks the packet as dirty after Buffer is modified in place, so
+// that the checksums are computed again before it is sent by SendPacket
+func (p *Packet) MarkDirty() {
+	p.dirty = true
+}
+
+// SetPayload replaces the payload with b, resizes Buffer and fixes the IPv4
+// total length, the IPv6 payload length and the UDP length. The checksums are
+// left untouched and the packet is marked as dirty. Buffer is reused when
+// the payload fits its capacity, and b may be Payload itself.
+func (p *Packet) SetPayload(b []byte) error {
+	off := p.payload
+
+	n := off + len(b)
+	if p.IPv4 != nil && n > 0xffff {
+		return errPacketLength
+	}
+	if p.IPv6 != nil && n-len(p.IPv6) > 0xffff {
+		return errPacketLength
+	}
+
+	p.Buffer = append(p.Buffer[:off], b...)
+	switch {
+	case p.IPv4 != nil:
+		IPv4Header(p.Buffer).SetLength(uint16(n))
+	case p.IPv6 != nil:
+		IPv6Header(p.Buffer).SetPayloadLength(uint16(n - len(p.IPv6)))
+	}
+	if p.UDP != nil {
+		UDPHeader(p.Buffer[p.transport:]).SetLength(uint16(n - p.transport))
+	}
+
+	p.dirty = true
+	return p.parse()
+}
+
+// CalcChecksums computes the IPv4, ICMP, ICMPv6, TCP and UDP checksums of the
+// packet, and the flags NoIPChecksum, NoICMPChecksum, NoICMPV6Checksum,
+// NoTCPChecksum and NoUDPChecksum skip the corresponding checksum. The
+// transport checksum of a fragment is left untouched.
+func (p *Packet) CalcChecksums(flags uint64) {
+	if p.IPv4 != nil && flags&NoIPChecksum == 0 {
+		p.IPv4.SetChecksum(0)
+		p.IPv4.SetChecksum(^checksum(p.IPv4, 0))
+	}
+
+	// the checksum of a fragment covers the reassembled packet
+	switch {
+	case p.Fragment:
+	case p.ICMP != nil && flags&NoICMPChecksum == 0:
+		p.ICMP.SetChecksum(0)
+		p.ICMP.SetChecksum(^checksum(p.segment(), 0))
+	case p.ICMPv6 != nil && flags&NoICMPV6Checksum == 0:
+		p.ICMPv6.SetChecksum(0)
+		p.ICMPv6.SetChecksum(^checksum(p.segment(), p.pseudoHeaderSum()))
+	case p.TCP != nil && flags&NoTCPChecksum == 0:
+		p.TCP.SetChecksum(0)
+		p.TCP.SetChecksum(^checksum(p.segment(), p.pseudoHeaderSum()))
+	case p.UDP != nil && flags&NoUDPChecksum == 0:
+		p.UDP.SetChecksum(0)
+		sum := ^checksum(p.segment(), p.pseudoHeaderSum())
+		if sum == 0 {
+			sum = 0xffff
+		}
+		p.UDP.SetChecksum(sum)
+	}
+
+	p.dirty = false
+}
+
+// segment returns the transport header and the payload
+func (p *Packet) segment() []byte {
+	return p.Buffer[p.transport : p.payload+len(p.Payload)]
+}
+
+func (p *Packet) pseudoHeaderSum() uint32 {
+	n := uint32(len(p.segment()))
+	sum := uint32(p.Protocol) + n>>16 + n&0xffff
+	switch {
+	case p.IPv4 != nil:
+		sum = uint32(checksum(p.IPv4[12:20], sum))
+	case p.IPv6 != nil:
+		sum = uint32(checksum(p.IPv6[8:40], sum))
+	}
+	return sum
+}
+
+// checksum returns the ones' complement sum of b added to initial, which is
+// not complemented
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for ; len(b) > 1; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+// PseudoHeaderChecksum returns the ones' complement sum of the pseudo header
+// of a TCP, UDP or ICMPv6 packet, which is not complemented, as the initial
+// sum of the checksum of the segment
+func PseudoHeaderChecksum(packet []byte) (uint16, error) {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return 0, err
+	}
+	if p.TCP == nil && p.UDP == nil && p.ICMPv6 == nil {
+		return 0, errPacketTransport
+	}
+	return uint16(p.pseudoHeaderSum()), nil
+}
+
+// IncrementalChecksumUpdate returns checksum updated for a 16-bit word of
+// the checksummed data which changes from the value from to the value to,
+// following equation 3 of RFC 1624
+func IncrementalChecksumUpdate(from, to, checksum uint16) uint16 {
+	sum := uint32(^checksum) + uint32(^from) + uint32(to)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,63 @@
+// +build windows,divert_cgo
+
+package divert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+// goOffsets returns the offsets of the headers and the payload of p, as
+// helperParsePacket does
+func goOffsets(p *Packet) packetOffsets {
+	base := uintptr(unsafe.Pointer(&p.Buffer[0]))
+	offset := func(b []byte) int {
+		if len(b) == 0 {
+			return -1
+		}
+		return int(uintptr(unsafe.Pointer(&b[0])) - base)
+	}
+	return packetOffsets{
+		IPv4:       offset(p.IPv4),
+		IPv6:       offset(p.IPv6),
+		ICMP:       offset(p.ICMP),
+		ICMPv6:     offset(p.ICMPv6),
+		TCP:        offset(p.TCP),
+		UDP:        offset(p.UDP),
+		Payload:    offset(p.Payload),
+		PayloadLen: len(p.Payload),
+		Protocol:   p.Protocol,
+	}
+}
+
+// TestParsePacketHelper checks that ParsePacket finds the same headers and
+// payload as WinDivertHelperParsePacket for the packets of the fuzz corpus
+func TestParsePacketHelper(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "fuzz", "corpus", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, ok := helperParsePacket(b)
+		if !ok {
+			t.Errorf("%v: the helper does not parse the packet", filepath.Base(file))
+			continue
+		}
+		p, err := ParsePacket(b)
+		if err != nil {
+			t.Errorf("%v: %v", filepath.Base(file), err)
+			continue
+		}
+		if got := goOffsets(p); got != want {
+			t.Errorf("%v: ParsePacket finds %+v, the helper finds %+v", filepath.Base(file), got, want)
+		}
+	}
+}
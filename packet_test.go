@@ -0,0 +1,63 @@
+// +build windows
+
+package divert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// editPacket is a step of the edit pipeline of ParsePacketInto, which bumps
+// the first byte of the payload in place and computes the checksums again
+func editPacket(p *Packet, buffer []byte) error {
+	if err := ParsePacketInto(p, buffer); err != nil {
+		return err
+	}
+	if len(p.Payload) > 0 {
+		p.Payload[0]++
+	}
+	p.MarkDirty()
+	p.CalcChecksums(ChecksumDefault)
+	return nil
+}
+
+func readPacket(tb testing.TB, name string) []byte {
+	b, err := os.ReadFile(filepath.Join("testdata", "fuzz", "corpus", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+func TestEditPacketAllocs(t *testing.T) {
+	buffer := readPacket(t, "ipv4-udp-dns")
+	p := Packet{}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := editPacket(&p, buffer); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("edit pipeline allocates %v times, want 0", allocs)
+	}
+	if p.Dirty() {
+		t.Error("packet is dirty after CalcChecksums")
+	}
+}
+
+// BenchmarkEditPacket runs ParsePacketInto, an edit in place, MarkDirty and
+// CalcChecksums, and reports no allocation per op
+func BenchmarkEditPacket(b *testing.B) {
+	buffer := readPacket(b, "ipv4-udp-dns")
+	p := Packet{}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buffer)))
+	for i := 0; i < b.N; i++ {
+		if err := editPacket(&p, buffer); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// PacketWriter writes a packet together with its address, which is used by
+// RecvTo instead of Write to frame packets
+type PacketWriter interface {
+	WritePacket(packet []byte, address *Address) (int, error)
+}
+
+// RecvTo receives a packet into a buffer from an internal pool and writes it
+// to w, with WritePacket if w is a PacketWriter. It returns the number of
+// bytes written to w.
+func (h *Handle) RecvTo(w io.Writer, address *Address) (int, error) {
+	bp := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(bp)
+
+	n, err := h.Recv(*bp, address)
+	if err != nil {
+		return 0, err
+	}
+
+	if pw, ok := w.(PacketWriter); ok {
+		return pw.WritePacket((*bp)[:n], address)
+	}
+	return w.Write((*bp)[:n])
+}
+
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d // the timestamps are in nanoseconds
+	pcapLinkTypeIP = 101        // LINKTYPE_RAW, packets begin with an IPv4 or IPv6 header
+)
+
+// PcapWriter writes packets in the pcap file format
+type PcapWriter struct {
+	w       io.Writer
+	buf     [16]byte
+	snapLen int
+}
+
+// NewPcapWriter writes the pcap file header to w and returns a PcapWriter
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	return NewPcapWriterSnap(w, 0)
+}
+
+// NewPcapWriterSnap is NewPcapWriter which writes at most the first snapLen
+// bytes of every packet, and the length of the whole packet is recorded. A
+// snapLen of 0 writes the whole packets.
+func NewPcapWriterSnap(w io.Writer, snapLen int) (*PcapWriter, error) {
+	if snapLen <= 0 || snapLen > MTUMax {
+		snapLen = MTUMax
+	}
+
+	hdr := [24]byte{}
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(snapLen))
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeIP)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w, snapLen: snapLen}, nil
+}
+
+// snap returns the first n bytes of packet, and the whole packet when n is 0
+func snap(packet []byte, n int) []byte {
+	if n > 0 && len(packet) > n {
+		return packet[:n]
+	}
+	return packet
+}
+
+// Write writes a packet captured now
+func (pw *PcapWriter) Write(packet []byte) (int, error) {
+	return pw.writePacket(packet, time.Now())
+}
+
+// WritePacket writes a packet captured at the timestamp of address, and at
+// the time of the call when address has no timestamp
+func (pw *PcapWriter) WritePacket(packet []byte, address *Address) (int, error) {
+	if address == nil || address.Timestamp == 0 {
+		return pw.writePacket(packet, time.Now())
+	}
+	return pw.writePacket(packet, address.Time())
+}
+
+func (pw *PcapWriter) writePacket(packet []byte, t time.Time) (int, error) {
+	data := snap(packet, pw.snapLen)
+
+	binary.LittleEndian.PutUint32(pw.buf[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(pw.buf[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(pw.buf[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(pw.buf[12:], uint32(len(packet)))
+
+	n, err := pw.w.Write(pw.buf[:])
+	if err != nil {
+		return n, err
+	}
+	m, err := pw.w.Write(data)
+	return n + m, err
+}
+
+// PcapReader reads the packets of a pcap file of raw IP packets, such as the
+// files written by PcapWriter, in either byte order and with microsecond or
+// nanosecond timestamps
+type PcapReader struct {
+	r       io.Reader
+	order   binary.ByteOrder
+	nano    bool
+	snapLen uint32
+	buf     [16]byte
+}
+
+// NewPcapReader reads the pcap file header from r and returns a PcapReader
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	hdr := [24]byte{}
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	pr := &PcapReader{r: r}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[0:]) {
+		case pcapMagic:
+			pr.order = order
+		case pcapMagicNano:
+			pr.order, pr.nano = order, true
+		}
+	}
+	if pr.order == nil || pr.order.Uint32(hdr[20:])&0xffff != pcapLinkTypeIP {
+		return nil, errPcapHeader
+	}
+
+	// the length of a packet is bounded before its buffer is allocated
+	pr.snapLen = pr.order.Uint32(hdr[16:])
+	if pr.snapLen == 0 || pr.snapLen > uint32(MTUMax) {
+		pr.snapLen = uint32(MTUMax)
+	}
+	return pr, nil
+}
+
+// ReadPacket returns the next packet and the time it was captured, and
+// io.EOF after the last packet. A packet longer than the snap length of the
+// file, or than MTUMax, is not read.
+func (pr *PcapReader) ReadPacket() ([]byte, time.Time, error) {
+	if _, err := io.ReadFull(pr.r, pr.buf[:]); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	sec, frac := int64(pr.order.Uint32(pr.buf[0:])), int64(pr.order.Uint32(pr.buf[4:]))
+	if !pr.nano {
+		frac *= 1000
+	}
+
+	n := pr.order.Uint32(pr.buf[8:])
+	if n > pr.snapLen {
+		return nil, time.Time{}, errPcapPacket
+	}
+
+	packet := make([]byte, n)
+	if _, err := io.ReadFull(pr.r, packet); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, time.Time{}, err
+	}
+	return packet, time.Unix(sec, frac), nil
+}
+
+// Replay sends the remaining packets to h as fast as possible
+func (pr *PcapReader) Replay(h *Handle) error {
+	return pr.ReplayTimed(h, 0)
+}
+
+// ReplayTimed sends the remaining packets to h as outbound packets of the
+// network layer, and paces them to reproduce the gaps between their
+// timestamps divided by speed, so that 2 replays twice as fast. A speed which
+// is not positive sends the packets as fast as possible. The packets are sent
+// with SendRecalc, and the gaps are kept from the first packet rather than
+// from the previous one so that the delays do not add up.
+// The packets cut by a snap length can not be sent and are skipped.
+func (pr *PcapReader) ReplayTimed(h *Handle, speed float64) error {
+	return pr.ReplayTimedContext(context.Background(), h, speed)
+}
+
+// ReplayTimedContext is ReplayTimed which also stops when ctx is done, also
+// while waiting for the next packet, and returns the error of ctx then
+func (pr *PcapReader) ReplayTimedContext(ctx context.Context, h *Handle, speed float64) error {
+	start, first := time.Time{}, time.Time{}
+	timer := (*time.Timer)(nil)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		packet, t, err := pr.ReadPacket()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if speed > 0 {
+			if start.IsZero() {
+				start, first = time.Now(), t
+			}
+			at := start.Add(time.Duration(float64(t.Sub(first)) / speed))
+			if d := time.Until(at); d > 0 {
+				if timer == nil {
+					timer = time.NewTimer(d)
+				} else {
+					timer.Reset(d)
+				}
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		if p, err := ParsePacket(packet); err == nil && p.Truncated {
+			continue
+		}
+
+		address := Address{}
+		address.SetLayer(LayerNetwork)
+		address.SetEvent(EventNetworkPacket)
+		address.SetOutbound(true)
+		address.SetIPv6(len(packet) > 0 && packet[0]>>4 == 6)
+		if _, err := h.SendRecalc(packet, &address); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// +build windows
+
+package divert
+
+import (
+	"fmt"
+	"time"
+)
+
+// reflectScanTimeout is how long openPriorities waits for the next event of
+// a handle which is already open
+const reflectScanTimeout = 100 * time.Millisecond
+
+// openPriorities returns the processes of the handles open on layer by their
+// priorities, with a process for every handle, which the reflect layer
+// reports as an open event for every handle when a handle is opened on it
+func openPriorities(layer Layer) (map[int16][]uint32, error) {
+	h, err := open("true", LayerReflect, PriorityDefault, FlagSniff|FlagRecvOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	buffer := make([]byte, MTUMax)
+	address := Address{}
+
+	priorities := make(map[int16][]uint32)
+	for {
+		n, err := h.RecvTimeout(buffer, &address, reflectScanTimeout)
+		if err != nil {
+			if err == ErrTimeout {
+				return priorities, nil
+			}
+			return nil, err
+		}
+
+		info, err := ParseReflect(buffer[:n], &address)
+		if err != nil || info.Layer != layer {
+			continue
+		}
+		switch address.Event() {
+		case EventReflectOpen:
+			priorities[info.Priority] = append(priorities[info.Priority], info.ProcessID)
+		case EventReflectClose:
+			priorities[info.Priority] = removePID(priorities[info.Priority], info.ProcessID)
+			if len(priorities[info.Priority]) == 0 {
+				delete(priorities, info.Priority)
+			}
+		}
+	}
+}
+
+// removePID removes a handle of pid from pids
+func removePID(pids []uint32, pid uint32) []uint32 {
+	for i := range pids {
+		if pids[i] == pid {
+			return append(pids[:i], pids[i+1:]...)
+		}
+	}
+	return pids
+}
+
+// checkPriority returns priority when no handle is open on layer with it, and
+// the next higher priority which is free when auto is set
+func checkPriority(layer Layer, priority int16, auto bool) (int16, error) {
+	priorities, err := openPriorities(layer)
+	if err != nil {
+		return 0, err
+	}
+
+	pids, ok := priorities[priority]
+	if !ok {
+		return priority, nil
+	}
+	if auto {
+		for p := int32(priority) + 1; p <= int32(PriorityHighest); p++ {
+			if _, ok := priorities[int16(p)]; !ok {
+				return int16(p), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: priority %v on %v is used by process %v", ErrPriorityConflict, priority, layer, pids[0])
+}
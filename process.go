@@ -0,0 +1,98 @@
+// +build windows
+
+package divert
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modKernel32                    = windows.NewLazySystemDLL("kernel32.dll")
+	procQueryFullProcessImageNameW = modKernel32.NewProc("QueryFullProcessImageNameW")
+)
+
+// processCacheTTL is how long a path is cached, a process ID can be reused by
+// a new process after the old one exits
+const processCacheTTL = 10 * time.Second
+
+type processEntry struct {
+	path    string
+	expires time.Time
+}
+
+var processCache = struct {
+	sync.Mutex
+	entries map[uint32]processEntry
+}{
+	entries: make(map[uint32]processEntry),
+}
+
+// ProcessPath returns the full path of the executable of the process, such as
+// the ProcessID of Flow and Socket. Paths are cached for a short time as the
+// same process ID recurs in events.
+func ProcessPath(pid uint32) (string, error) {
+	switch pid {
+	case 0:
+		return "System Idle Process", nil
+	case 4:
+		return "System", nil
+	}
+
+	now := time.Now()
+
+	processCache.Lock()
+	entry, ok := processCache.entries[pid]
+	processCache.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.path, nil
+	}
+
+	path, err := queryProcessPath(pid)
+	if err != nil {
+		return "", err
+	}
+
+	processCache.Lock()
+	if len(processCache.entries) >= 1024 {
+		for k, v := range processCache.entries {
+			if now.After(v.expires) {
+				delete(processCache.entries, k)
+			}
+		}
+	}
+	processCache.entries[pid] = processEntry{path: path, expires: now.Add(processCacheTTL)}
+	processCache.Unlock()
+
+	return path, nil
+}
+
+// ProcessName returns the file name of the executable of the process
+func ProcessName(pid uint32) (string, error) {
+	path, err := ProcessPath(pid)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(path), nil
+}
+
+func queryProcessPath(pid uint32) (string, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	r, _, err := procQueryFullProcessImageNameW.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n)))
+	if r == 0 {
+		return "", err
+	}
+
+	return windows.UTF16ToString(buf[:n]), nil
+}
@@ -0,0 +1,205 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// Completion is an operation of RecvAsync or SendAsync which is completed
+type Completion struct {
+	Handle     *Handle
+	Overlapped *Overlapped
+	N          uint
+	Err        error
+}
+
+// Recv reports whether the completed operation is a receive
+func (c *Completion) Recv() bool {
+	return c.Overlapped.code == ioCtlRecv
+}
+
+// Reactor services the operations of many handles with one completion port
+// and a small pool of workers, instead of one goroutine blocked in Recv for
+// every handle. Completions are dispatched to the function of the reactor
+// from any of the workers.
+type Reactor struct {
+	port    windows.Handle
+	handler func(*Completion)
+
+	mu      sync.Mutex
+	handles map[uint32]*Handle
+	next    uint32
+
+	// the driver only keeps the address of a receive started by Start, which
+	// must stay reachable until it completes
+	owned map[*Overlapped]struct{}
+
+	workers int
+	wg      sync.WaitGroup
+
+	// done is closed by Close, and stops watching the contexts of
+	// StartContext
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReactor returns a Reactor running workers goroutines, which call handler
+// for every completion
+func NewReactor(workers int, handler func(*Completion)) (*Reactor, error) {
+	if workers < 1 {
+		panic("divert: number of workers is less than 1")
+	}
+
+	port, err := windows.CreateIoCompletionPort(windows.InvalidHandle, 0, 0, uint32(workers))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reactor{
+		port:    port,
+		handler: handler,
+		handles: make(map[uint32]*Handle),
+		owned:   make(map[*Overlapped]struct{}),
+		workers: workers,
+		done:    make(chan struct{}),
+	}
+
+	r.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go r.run()
+	}
+
+	return r, nil
+}
+
+// Add associates h with the completion port of the reactor, and the
+// completions of RecvAsync and SendAsync of h are dispatched by the reactor
+func (r *Reactor) Add(h *Handle) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// key 0 is kept for waking up the workers in Close
+	r.next++
+	if _, err := h.AssociateCompletionPort(r.port, r.next); err != nil {
+		return err
+	}
+	r.handles[r.next] = h
+
+	return nil
+}
+
+// Start adds h and keeps n receives of MTUMax bytes in flight on it. Each of
+// them is started again after the handler returns, until it fails, which is
+// the case when h is shut down or closed.
+func (r *Reactor) Start(h *Handle, n int) error {
+	return r.StartContext(context.Background(), h, n)
+}
+
+// StartContext is Start which stops starting the receives again when ctx is
+// done, and cancels the pending ones with CancelIoEx, whose completions are
+// dispatched with ERROR_OPERATION_ABORTED
+func (r *Reactor) StartContext(ctx context.Context, h *Handle, n int) error {
+	if err := r.Add(h); err != nil {
+		return err
+	}
+
+	started := make([]*Overlapped, 0, n)
+	defer func() {
+		if len(started) == 0 || ctx.Done() == nil {
+			return
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+				for _, o := range started {
+					windows.CancelIoEx(h.handle(), &o.Overlapped)
+				}
+			case <-r.done:
+			}
+		}()
+	}()
+
+	for i := 0; i < n; i++ {
+		o, err := NewOverlapped()
+		if err != nil {
+			return err
+		}
+		o.rearm, o.ctx = true, ctx
+
+		r.mu.Lock()
+		r.owned[o] = struct{}{}
+		r.mu.Unlock()
+
+		if err := h.RecvAsync(make([]byte, MTUMax), make([]Address, 1), o); err != nil {
+			r.mu.Lock()
+			delete(r.owned, o)
+			r.mu.Unlock()
+			o.Close()
+			return err
+		}
+		started = append(started, o)
+	}
+
+	return nil
+}
+
+func (r *Reactor) run() {
+	defer r.wg.Done()
+
+	for {
+		iolen, key, overlapped := uint32(0), uint32(0), (*windows.Overlapped)(nil)
+		err := windows.GetQueuedCompletionStatus(r.port, &iolen, &key, &overlapped, windows.INFINITE)
+		if overlapped == nil {
+			// posted by Close, or the port is closed
+			return
+		}
+
+		r.mu.Lock()
+		h := r.handles[key]
+		r.mu.Unlock()
+
+		c := &Completion{
+			Handle:     h,
+			Overlapped: OverlappedOf(overlapped),
+			N:          uint(iolen),
+		}
+		if err != nil {
+			c.Err = Error(err.(windows.Errno))
+		}
+		r.handler(c)
+
+		o := c.Overlapped
+		if !o.rearm {
+			continue
+		}
+		if c.Err == nil && o.ctx.Err() == nil && h.RecvAsync(o.buffer, o.address, o) == nil {
+			// ctx may be done before the receive is started again, and after
+			// StartContext cancels the receives
+			if o.ctx.Err() != nil {
+				windows.CancelIoEx(h.handle(), &o.Overlapped)
+			}
+			continue
+		}
+
+		r.mu.Lock()
+		delete(r.owned, o)
+		r.mu.Unlock()
+		o.Close()
+	}
+}
+
+// Close stops the workers and closes the completion port, and the handles
+// are left open
+func (r *Reactor) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	for i := 0; i < r.workers; i++ {
+		windows.PostQueuedCompletionStatus(r.port, 0, 0, nil)
+	}
+	r.wg.Wait()
+
+	return windows.CloseHandle(r.port)
+}
@@ -0,0 +1,55 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// waitReadyInterval is how often WaitReady tries to open a handle
+const waitReadyInterval = 50 * time.Millisecond
+
+// WaitReady waits for the driver to be available, such as after the WinDivert
+// service is installed, by opening and closing a handle which captures
+// nothing until it succeeds or timeout elapses. It returns the last error of
+// Open on timeout, and at once the errors which do not go away by waiting,
+// such as ErrAccessDenied, ErrDriverBlocked and ErrVersionMismatch.
+func WaitReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return WaitReadyContext(ctx)
+}
+
+// WaitReadyContext is WaitReady which waits until ctx is done rather than for
+// a timeout, and returns the last error of Open then
+func WaitReadyContext(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+
+	for {
+		h, err := Open("false", LayerNetwork, PriorityDefault, FlagSniff|FlagRecvOnly)
+		if err == nil {
+			return h.Close()
+		}
+
+		for _, e := range []error{ErrAccessDenied, ErrInvalidImageHash, ErrDriverBlocked, ErrVersionMismatch, ErrInvalidParameter} {
+			if errors.Is(err, e) {
+				return err
+			}
+		}
+
+		if ok && time.Now().Add(waitReadyInterval).After(deadline) {
+			return err
+		}
+
+		timer := time.NewTimer(waitReadyInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// +build windows
+
+package divert
+
+import "time"
+
+// reassemblyMaxBuffered is the default of TCPReassembler.MaxBuffered
+const reassemblyMaxBuffered = 1 << 20
+
+// TCPReassembler reassembles the TCP byte streams of the packets added to it,
+// one stream for every direction of a connection as keyed by FlowKey. It
+// puts out-of-order segments back in order, drops retransmitted bytes and
+// handles the wraparound of sequence numbers. It is for one goroutine, and a
+// zero TCPReassembler is ready to use.
+type TCPReassembler struct {
+	// OnData is called with the next bytes of the stream of key in order,
+	// and data is only valid during the call
+	OnData func(key FlowKey, data []byte)
+
+	// OnClose is called when the stream of key ends by FIN or RST, or is
+	// expired by Expire
+	OnClose func(key FlowKey)
+
+	// MaxBuffered is the maximum of out-of-order bytes kept for a stream,
+	// and a segment beyond it is dropped. It is 1 MiB when it is 0.
+	MaxBuffered int
+
+	streams map[FlowKey]*tcpStream
+
+	// closed are the streams which are closed, by the time they are closed,
+	// so that the packets after the end of a stream, such as the last ACK,
+	// do not start it again until a SYN or Expire
+	closed map[FlowKey]time.Time
+}
+
+type tcpStream struct {
+	next     uint32
+	segments []tcpSegment
+	buffered int
+
+	fin    bool
+	finSeq uint32
+
+	lastSeen time.Time
+}
+
+type tcpSegment struct {
+	seq  uint32
+	data []byte
+}
+
+// NewTCPReassembler returns a TCPReassembler with callbacks, and either of
+// them may be nil
+func NewTCPReassembler(onData func(FlowKey, []byte), onClose func(FlowKey)) *TCPReassembler {
+	return &TCPReassembler{
+		OnData:      onData,
+		OnClose:     onClose,
+		MaxBuffered: reassemblyMaxBuffered,
+	}
+}
+
+// seqDiff returns a - b of sequence numbers with wraparound
+func seqDiff(a, b uint32) int32 {
+	return int32(a - b)
+}
+
+// Add adds a TCP packet, and reports whether it is a TCP packet. A stream
+// starts at the SYN, or at the first packet seen of a connection which is
+// already established.
+func (r *TCPReassembler) Add(p *Packet) bool {
+	if p.TCP == nil {
+		return false
+	}
+	key, _ := FlowKeyOf(p)
+	seq := p.TCP.SeqNum()
+
+	if r.streams == nil {
+		r.streams = make(map[FlowKey]*tcpStream)
+		r.closed = make(map[FlowKey]time.Time)
+	}
+
+	if p.TCP.Rst() {
+		r.close(key)
+		r.close(key.Reverse())
+		return true
+	}
+
+	if _, ok := r.closed[key]; ok {
+		if !p.TCP.Syn() {
+			return true
+		}
+		delete(r.closed, key)
+	}
+
+	s, ok := r.streams[key]
+	if !ok {
+		s = &tcpStream{next: seq}
+		r.streams[key] = s
+	}
+	s.lastSeen = time.Now()
+
+	if p.TCP.Syn() {
+		// the SYN takes a sequence number
+		if !ok || seqDiff(seq+1, s.next) > 0 {
+			s.next = seq + 1
+		}
+		seq++
+	}
+
+	if p.TCP.Fin() && !s.fin {
+		s.fin, s.finSeq = true, seq+uint32(len(p.Payload))
+	}
+
+	r.push(key, s, seq, p.Payload)
+	return true
+}
+
+// AddPacket parses packet and adds it
+func (r *TCPReassembler) AddPacket(packet []byte) bool {
+	p, err := ParsePacket(packet)
+	if err != nil {
+		return false
+	}
+	return r.Add(p)
+}
+
+func (r *TCPReassembler) push(key FlowKey, s *tcpStream, seq uint32, data []byte) {
+	switch {
+	case len(data) == 0:
+	case seqDiff(seq, s.next) > 0:
+		r.buffer(s, seq, data)
+	default:
+		r.deliver(key, s, seq, data)
+	}
+
+	// the buffered segments which are in order now
+	for drained := true; drained; {
+		drained = false
+		for i := 0; i < len(s.segments); i++ {
+			seg := s.segments[i]
+			if seqDiff(seg.seq, s.next) > 0 {
+				continue
+			}
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.buffered -= len(seg.data)
+			r.deliver(key, s, seg.seq, seg.data)
+			drained = true
+			break
+		}
+	}
+
+	if s.fin && s.next == s.finSeq {
+		r.close(key)
+	}
+}
+
+// deliver passes the bytes of data after s.next to OnData, and data does not
+// start after s.next
+func (r *TCPReassembler) deliver(key FlowKey, s *tcpStream, seq uint32, data []byte) {
+	skip := int(-seqDiff(seq, s.next))
+	if skip >= len(data) {
+		// a retransmission of bytes which are delivered
+		return
+	}
+	data = data[skip:]
+	s.next += uint32(len(data))
+
+	if r.OnData != nil {
+		r.OnData(key, data)
+	}
+}
+
+func (r *TCPReassembler) buffer(s *tcpStream, seq uint32, data []byte) {
+	for _, seg := range s.segments {
+		if seg.seq == seq && len(seg.data) >= len(data) {
+			return
+		}
+	}
+	max := r.MaxBuffered
+	if max == 0 {
+		max = reassemblyMaxBuffered
+	}
+	if s.buffered+len(data) > max {
+		return
+	}
+
+	s.segments = append(s.segments, tcpSegment{seq: seq, data: append([]byte(nil), data...)})
+	s.buffered += len(data)
+}
+
+func (r *TCPReassembler) close(key FlowKey) {
+	if _, ok := r.streams[key]; !ok {
+		return
+	}
+	delete(r.streams, key)
+	r.closed[key] = time.Now()
+
+	if r.OnClose != nil {
+		r.OnClose(key)
+	}
+}
+
+// Expire closes the streams which are not seen for idle, and forgets the
+// streams closed before idle
+func (r *TCPReassembler) Expire(idle time.Duration) {
+	now := time.Now()
+	for key, t := range r.closed {
+		if now.Sub(t) > idle {
+			delete(r.closed, key)
+		}
+	}
+	for key, s := range r.streams {
+		if now.Sub(s.lastSeen) > idle {
+			// a stream which is only idle starts again by its next packet
+			r.close(key)
+			delete(r.closed, key)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// +build windows
+
+package divert
+
+import (
+	"runtime"
+	"time"
+)
+
+// DedicatedReceiver receives packets from a handle on a goroutine which is
+// locked to its OS thread for its lifetime, so that the overlapped receives
+// are always issued and waited on the same thread, and delivers them on a
+// channel
+type DedicatedReceiver struct {
+	h       *Handle
+	packets chan CapturedPacket
+	done    chan struct{}
+	err     error
+}
+
+// NewDedicatedReceiver starts receiving packets from h, and buffers up to n
+// packets which are not received from Packets
+func NewDedicatedReceiver(h *Handle, n int) *DedicatedReceiver {
+	r := &DedicatedReceiver{
+		h:       h,
+		packets: make(chan CapturedPacket, n),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Packets returns the channel of packets, which is closed when the handle is
+// shut down or receiving fails
+func (r *DedicatedReceiver) Packets() <-chan CapturedPacket {
+	return r.packets
+}
+
+// Err returns the error which stops receiving, and nil when the handle is
+// shut down. It must be called after Packets is closed.
+func (r *DedicatedReceiver) Err() error {
+	<-r.done
+	return r.err
+}
+
+// Close shuts down receiving of the handle, drops the packets which are not
+// received from Packets and waits for the goroutine to stop. The handle is
+// not closed.
+func (r *DedicatedReceiver) Close() error {
+	err := r.h.Shutdown(ShutdownRecv)
+	for range r.packets {
+	}
+	<-r.done
+	return err
+}
+
+func (r *DedicatedReceiver) run() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer close(r.done)
+	defer close(r.packets)
+
+	buffer := make([]byte, MTUMax)
+	address := Address{}
+
+	for {
+		n, err := r.h.Recv(buffer, &address)
+		if err != nil {
+			if err != ErrNoData {
+				r.err = err
+			}
+			return
+		}
+
+		r.packets <- CapturedPacket{
+			Data:    append([]byte(nil), buffer[:n]...),
+			Address: address,
+			Time:    time.Now(),
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// +build windows
+
+package divert
+
+import "bytes"
+
+// ReflectInfo is a handle opened on the system, as reported by an event of
+// the reflect layer. Filter is the filter of the handle in the object format
+// of CompileFilter, which OpenCompiled accepts and NormalizeFilter renders
+// as a filter string.
+type ReflectInfo struct {
+	Filter    string
+	Layer     Layer
+	Priority  int16
+	Flags     uint64
+	ProcessID uint32
+}
+
+// ParseReflect parses the packet and the address of an event received on the
+// reflect layer, of which the packet is the filter of the handle
+func ParseReflect(packet []byte, address *Address) (ReflectInfo, error) {
+	if address.Layer() != LayerReflect {
+		return ReflectInfo{}, errReflectLayer
+	}
+
+	if i := bytes.IndexByte(packet, 0); i >= 0 {
+		packet = packet[:i]
+	}
+
+	r := address.Reflect()
+	return ReflectInfo{
+		Filter:    string(packet),
+		Layer:     r.Layer(),
+		Priority:  r.Priority,
+		Flags:     r.Flags,
+		ProcessID: r.ProcessID,
+	}, nil
+}
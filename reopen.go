@@ -0,0 +1,85 @@
+// +build windows
+
+package divert
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// IsValid reports whether the handle is still working, by getting a parameter
+// from the driver. A handle may stop working after the system resumes from
+// sleep or the driver is restarted, and then every call returns an error.
+func (h *Handle) IsValid() bool {
+	_, err := h.GetParam(VersionMajor)
+	return err == nil
+}
+
+// Reopen opens a new handle with the arguments of Open and the parameters set
+// by SetParam, and replaces the handle with it. It must not be called while
+// other goroutines are using the handle.
+func (h *Handle) Reopen() error {
+	if h.handle() == windows.InvalidHandle {
+		return ErrClosed
+	}
+
+	nh, err := open(h.filter, h.layer, h.priority, h.flags)
+	if err != nil {
+		return err
+	}
+	// nh only lends its handle to h, and has no events as it is not used
+	runtime.SetFinalizer(nh, nil)
+
+	h.paramMu.Lock()
+	set := h.set
+	h.paramMu.Unlock()
+	for p, v := range set {
+		if err := nh.SetParam(p, v); err != nil {
+			windows.CloseHandle(nh.Handle)
+			return err
+		}
+	}
+
+	// the handle may be closed meanwhile, and then nh is closed instead
+	old := h.handle()
+	if old == windows.InvalidHandle || !atomic.CompareAndSwapUintptr((*uintptr)(unsafe.Pointer(&h.Handle)), uintptr(old), uintptr(nh.Handle)) {
+		windows.CloseHandle(nh.Handle)
+		return ErrClosed
+	}
+	windows.CloseHandle(old)
+	h.InvalidateParams()
+
+	return nil
+}
+
+// reopenRetries is how many times Recv, RecvEx and RecvContext reopen the
+// handle and receive again, and the error is returned after that rather than
+// reopening without end while the driver keeps failing
+const reopenRetries = 3
+
+// SetAutoReopen sets whether Recv and RecvEx reopen the handle with Reopen and
+// receive again, when they fail and the handle is no longer valid, up to
+// three times before the error is returned. It is for a handle which
+// is only used by one goroutine.
+func (h *Handle) SetAutoReopen(b bool) {
+	h.autoReopen = b
+}
+
+func (h *Handle) reopenIfInvalid(err error) bool {
+	if !h.autoReopen || h.handle() == windows.InvalidHandle {
+		return false
+	}
+
+	switch err {
+	case errBatchSize, ErrNoData, ErrInsufficientBuffer, ErrHostUnreachable, ErrTimeout, ErrWouldBlock:
+		return false
+	}
+	if h.IsValid() {
+		return false
+	}
+
+	return h.Reopen() == nil
+}
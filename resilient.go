@@ -0,0 +1,168 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ResilientHandle is a Handle which survives being invalidated, such as after
+// the system resumes from sleep or the driver is restarted. When Recv or Send
+// fails and the handle is no longer valid, it is reopened with Reopen, trying
+// again with an exponential backoff, and the call is made again.
+type ResilientHandle struct {
+	*Handle
+
+	// MinBackoff and MaxBackoff bound the time between two attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnReconnect is called after every attempt to reopen the handle, with
+	// the error of the attempt, which is nil when the handle is reopened
+	OnReconnect func(attempt int, err error)
+
+	mu     sync.Mutex
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+}
+
+// OpenResilient opens a ResilientHandle, with a backoff from 100 milliseconds
+// to 30 seconds
+func OpenResilient(filter string, layer Layer, priority int16, flags uint64) (*ResilientHandle, error) {
+	h, err := Open(filter, layer, priority, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResilientHandle{
+		Handle:     h,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Recv is Handle.Recv which reopens an invalidated handle
+func (r *ResilientHandle) Recv(buffer []byte, address *Address) (uint, error) {
+	for {
+		n, err := r.Handle.Recv(buffer, address)
+		if err == nil || !r.reconnect(err) {
+			return n, err
+		}
+	}
+}
+
+// Send is Handle.Send which reopens an invalidated handle
+func (r *ResilientHandle) Send(buffer []byte, address *Address) (uint, error) {
+	for {
+		n, err := r.Handle.Send(buffer, address)
+		if err == nil || !r.reconnect(err) {
+			return n, err
+		}
+	}
+}
+
+// ForEach is Handle.ForEach which keeps receiving after the handle is
+// reopened
+func (r *ResilientHandle) ForEach(handler Handler) error {
+	buffer := make([]byte, MTUMax)
+	address := new(Address)
+
+	for {
+		n, err := r.Recv(buffer, address)
+		if err != nil {
+			if err == ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		if err := handler(buffer[:n], address); err != nil {
+			return err
+		}
+	}
+}
+
+// ForEachContext is Handle.ForEachContext which keeps receiving after the
+// handle is reopened
+func (r *ResilientHandle) ForEachContext(ctx context.Context, handler Handler) error {
+	c, err := newCancelEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buffer := make([]byte, MTUMax)
+	address := new(Address)
+
+	for {
+		n, err := r.Handle.recvCancel(ctx, c, buffer, address)
+		if err != nil {
+			if err != ctx.Err() && r.reconnect(err) {
+				continue
+			}
+			if err == ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		if err := handler(buffer[:n], address); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect reopens the handle when err is caused by an invalidated handle,
+// and reports whether the call should be made again
+func (r *ResilientHandle) reconnect(err error) bool {
+	switch err {
+	case ErrNoData, ErrInsufficientBuffer, ErrHostUnreachable:
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || r.IsValid() {
+		return false
+	}
+
+	backoff := r.MinBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.done:
+			return false
+		case <-time.After(backoff):
+		}
+
+		err := r.Reopen()
+		if r.OnReconnect != nil {
+			r.OnReconnect(attempt, err)
+		}
+		if err == nil {
+			return true
+		}
+
+		if backoff *= 2; backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// Close stops reopening the handle and closes it, and returns ErrClosed after
+// the first call
+func (r *ResilientHandle) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	return r.Handle.Close()
+}
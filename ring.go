@@ -0,0 +1,110 @@
+// +build windows
+
+package divert
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CapturedPacket is a packet with its address and the time it is received
+type CapturedPacket struct {
+	Data    []byte
+	Address Address
+	Time    time.Time
+}
+
+// RingCapture keeps the last N packets received from a handle, which should
+// be opened with FlagSniff, so that they can be dumped when something happens
+type RingCapture struct {
+	h *Handle
+
+	mu   sync.Mutex
+	ring []CapturedPacket
+	next int
+	full bool
+
+	snapLen int
+}
+
+// NewRingCapture returns a RingCapture which keeps the last n packets of h
+func NewRingCapture(h *Handle, n int) *RingCapture {
+	if n < 1 {
+		panic("divert: size of ring capture is less than 1")
+	}
+	return &RingCapture{
+		h:    h,
+		ring: make([]CapturedPacket, n),
+	}
+}
+
+// SetSnapLen keeps at most the first n bytes of every packet, such as only
+// the headers, and 0 keeps the whole packets. It must be called before Run,
+// and the packets diverted by the handle are not cut.
+func (r *RingCapture) SetSnapLen(n int) {
+	r.snapLen = n
+}
+
+// Run receives packets until the handle is shut down
+func (r *RingCapture) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is Run which also stops when ctx is done, and returns the error
+// of ctx then. The pending receive is canceled as RecvContext does.
+func (r *RingCapture) RunContext(ctx context.Context) error {
+	c, err := newCancelEvent(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buffer := make([]byte, MTUMax)
+	address := Address{}
+
+	for {
+		n, err := r.h.recvCancel(ctx, c, buffer, &address)
+		if err != nil {
+			if err == ErrNoData {
+				return nil
+			}
+			return err
+		}
+
+		r.mu.Lock()
+		slot := &r.ring[r.next]
+		slot.Data = append(slot.Data[:0], snap(buffer[:n], r.snapLen)...)
+		slot.Address = address
+		slot.Time = time.Now()
+		r.next++
+		if r.next == len(r.ring) {
+			r.next = 0
+			r.full = true
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Snapshot returns copies of the kept packets from the oldest to the newest,
+// and it is safe to call while Run is receiving packets
+func (r *RingCapture) Snapshot() []CapturedPacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start, n := 0, r.next
+	if r.full {
+		start, n = r.next, len(r.ring)
+	}
+
+	packets := make([]CapturedPacket, 0, n)
+	for i := 0; i < n; i++ {
+		slot := &r.ring[(start+i)%len(r.ring)]
+		packets = append(packets, CapturedPacket{
+			Data:    append([]byte(nil), slot.Data...),
+			Address: slot.Address,
+			Time:    slot.Time,
+		})
+	}
+	return packets
+}
@@ -0,0 +1,104 @@
+// +build windows
+
+package divert
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// selfTestTimeout is how long SelfTest waits for the injected packet
+const selfTestTimeout = 2 * time.Second
+
+// SelfTest checks that the driver works end to end. It opens a handle which
+// captures and drops a crafted UDP packet to 127.0.0.1:9, injects the packet
+// from a second handle of a higher priority and waits for it to be captured.
+// All the handles are closed before it returns.
+func SelfTest() error {
+	return SelfTestContext(context.Background())
+}
+
+// SelfTestContext is SelfTest which also stops waiting for the packet when
+// ctx is done, and returns the error of ctx then
+func SelfTestContext(ctx context.Context) error {
+	marker := make([]byte, 16)
+	if _, err := rand.Read(marker); err != nil {
+		return err
+	}
+	packet := selfTestPacket(marker)
+
+	filter := fmt.Sprintf("loopback and outbound and udp.DstPort == 9 and udp.PayloadLength == %v", len(marker))
+	rh, err := Open(filter, LayerNetwork, PriorityDefault, FlagDefault)
+	if err != nil {
+		return err
+	}
+	defer rh.Close()
+
+	sh, err := Open("false", LayerNetwork, PriorityHighest, FlagSendOnly)
+	if err != nil {
+		return err
+	}
+	defer sh.Close()
+
+	address := Address{}
+	address.SetLayer(LayerNetwork)
+	address.SetEvent(EventNetworkPacket)
+	address.SetOutbound(true)
+	address.SetLoopback(true)
+	address.Network().InterfaceIndex = 1
+	if _, err := sh.Send(packet, &address); err != nil {
+		return err
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	c, err := newCancelEvent(rctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buffer := make([]byte, MTUMax)
+	for {
+		n, err := rh.recvCancel(rctx, c, buffer, &address)
+		if err != nil {
+			if err == context.DeadlineExceeded && ctx.Err() == nil {
+				return errSelfTest
+			}
+			return err
+		}
+
+		if bytes.HasSuffix(buffer[:n], marker) {
+			return nil
+		}
+	}
+}
+
+// selfTestPacket returns an IPv4 UDP packet from 127.0.0.1:9 to 127.0.0.1:9
+// with payload
+func selfTestPacket(payload []byte) []byte {
+	b := make([]byte, 28, 28+len(payload))
+	b[0] = 0x45
+
+	ip := IPv4Header(b)
+	ip.SetLength(uint16(28 + len(payload)))
+	ip.SetTTL(64)
+	b[9] = byte(ProtoUDP)
+	ip.SetSrcAddr([4]byte{127, 0, 0, 1})
+	ip.SetDstAddr([4]byte{127, 0, 0, 1})
+
+	udp := UDPHeader(b[20:])
+	udp.SetSrcPort(9)
+	udp.SetDstPort(9)
+	udp.SetLength(uint16(8 + len(payload)))
+
+	b = append(b, payload...)
+	if p, err := ParsePacket(b); err == nil {
+		p.CalcChecksums(ChecksumDefault)
+	}
+	return b
+}
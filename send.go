@@ -0,0 +1,102 @@
+// +build windows
+
+package divert
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+)
+
+// sendGate hands out the overlappeds of Send and SendEx, so that every
+// overlapped is used by one send at a time. The senders wait on a channel,
+// which wakes them in FIFO order without spinning.
+type sendGate struct {
+	// ready is set once the slots are created, so that a send after the
+	// first one does not take mu
+	ready uint32
+	mu    sync.Mutex
+	slots chan *sendSlot
+
+	// extra are the overlappeds beyond the wOverlapped of the handle, of a
+	// concurrency set by SetSendConcurrency
+	extra []*windows.Overlapped
+}
+
+// init creates the slots with the wOverlapped of h and n-1 extra overlappeds
+// when they are not created yet, and reports whether it creates them
+func (g *sendGate) init(h *Handle, n int) (bool, error) {
+	if atomic.LoadUint32(&g.ready) == 1 {
+		return false, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.slots != nil {
+		return false, nil
+	}
+
+	extra := make([]*windows.Overlapped, 0, n-1)
+	for len(extra) < n-1 {
+		event, err := windows.CreateEvent(nil, 0, 0, nil)
+		if err != nil {
+			for _, o := range extra {
+				windows.CloseHandle(o.HEvent &^ 1)
+			}
+			return false, Error(err.(windows.Errno))
+		}
+		// the low-order bit keeps the completion out of a completion port
+		extra = append(extra, &windows.Overlapped{HEvent: event | 1})
+	}
+
+	g.slots = make(chan *sendSlot, n)
+	g.slots <- &sendSlot{overlapped: h.writeOverlapped()}
+	for _, o := range extra {
+		g.slots <- &sendSlot{overlapped: o}
+	}
+	g.extra = extra
+	atomic.StoreUint32(&g.ready, 1)
+	return true, nil
+}
+
+// SetSendConcurrency sets the number of Send and SendEx which are in flight
+// at once, each with an overlapped of its own, and the others wait in FIFO
+// order. It is 1 by default, and it must be called before the first send.
+func (h *Handle) SetSendConcurrency(n int) error {
+	if h.handle() == windows.InvalidHandle {
+		return ErrClosed
+	}
+
+	if n < 1 {
+		return errSendConcurrency
+	}
+
+	ok, err := h.sendGate.init(h, n)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errSendStarted
+	}
+	return nil
+}
+
+// sendSlot is an overlapped and the request of a send using it, which is
+// reused by every send holding the slot rather than set up for every call
+type sendSlot struct {
+	overlapped *windows.Overlapped
+	req        send
+}
+
+// acquireSend waits for a slot which no send is using
+func (h *Handle) acquireSend() *sendSlot {
+	h.sendGate.init(h, 1)
+	return <-h.sendGate.slots
+}
+
+// releaseSend returns a slot taken by acquireSend
+func (h *Handle) releaseSend(s *sendSlot) {
+	h.sendGate.slots <- s
+}
@@ -0,0 +1,48 @@
+// +build windows
+
+package divert
+
+import "testing"
+
+// loopbackPacket returns an ICMP echo request from 127.0.0.1 to 127.0.0.1
+// with the checksums computed, which is sent without leaving the host
+func loopbackPacket(tb testing.TB) []byte {
+	buffer := []byte{
+		0x45, 0x00, 0x00, 0x1c, 0x00, 0x01, 0x00, 0x00, 0x40, 0x01, 0x00, 0x00,
+		127, 0, 0, 1,
+		127, 0, 0, 1,
+		0x08, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01,
+	}
+	p, err := ParsePacket(buffer)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	p.CalcChecksums(ChecksumDefault)
+	return buffer
+}
+
+// BenchmarkSend sends a loopback packet on a send-only handle, and reports no
+// allocation per op, as every send reuses a slot of the send gate. It needs
+// the driver, and is skipped without it.
+func BenchmarkSend(b *testing.B) {
+	h, err := Open("false", LayerNetwork, PriorityDefault, FlagSendOnly)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer h.Close()
+
+	buffer := loopbackPacket(b)
+	address := Address{}
+	address.SetLayer(LayerNetwork)
+	address.SetOutbound(true)
+	address.SetLoopback(true)
+	address.SetIPChecksum(true)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.Send(buffer, &address); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
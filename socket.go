@@ -0,0 +1,37 @@
+// +build windows
+
+package divert
+
+// The socket layer does not take events back. A handle on the socket layer
+// opened without FlagSniff blocks every socket operation which matches its
+// filter, and a handle opened with FlagSniff allows every one of them, so the
+// verdict is made by the flags and the filter of the handle when it is opened
+// rather than by sending or not sending an event. Send fails on the socket
+// layer, and a firewall of applications blocks the operations of a process
+// with a handle whose filter matches them, such as processId == 1234.
+
+// AllowSocket checks that the socket operation of address is allowed, which
+// is the case when the handle is opened with FlagSniff, and returns an error
+// otherwise, as the operation is already blocked
+func (h *Handle) AllowSocket(address *Address) error {
+	if address.Layer() != LayerSocket || h.layer != LayerSocket {
+		return errSocketLayer
+	}
+	if h.flags&FlagSniff == 0 {
+		return errSocketVerdict
+	}
+	return nil
+}
+
+// DenySocket checks that the socket operation of address is blocked, which
+// is the case when the handle is opened without FlagSniff, and returns an
+// error otherwise, as the operation is already allowed
+func (h *Handle) DenySocket(address *Address) error {
+	if address.Layer() != LayerSocket || h.layer != LayerSocket {
+		return errSocketLayer
+	}
+	if h.flags&FlagSniff != 0 {
+		return errSocketVerdict
+	}
+	return nil
+}
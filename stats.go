@@ -0,0 +1,119 @@
+// +build windows
+
+package divert
+
+import (
+	"sync"
+	"time"
+)
+
+// QueueStats is what is known about the packet queue of a handle. The driver
+// counts neither the packets in the queue nor the packets it drops, which are
+// the packets queued for longer than QueueTime or beyond QueueLength and
+// QueueSize. The delay of a received packet is measured from its timestamp,
+// and a MaxDelay approaching Time means the packets are about to be dropped.
+type QueueStats struct {
+	// the limits of the queue, QueueLength, QueueSize and QueueTime
+	Length uint64
+	Size   uint64
+	Time   time.Duration
+
+	// Received is the number of packets received since the handle is opened
+	Received uint64
+
+	// MaxDelay is the longest time a packet received since the last call of
+	// QueueStats was queued
+	MaxDelay time.Duration
+}
+
+// queueCounters are updated by the receives of a handle
+type queueCounters struct {
+	mu       sync.Mutex
+	received uint64
+	maxDelay time.Duration
+
+	// the delay of the last received packet, and the rate of receiving in
+	// packets per second, averaged over the receives
+	lastDelay time.Duration
+	rate      float64
+	lastRecv  int64
+}
+
+// rateWeight is the weight of a receive in queueCounters.rate
+const rateWeight = 0.125
+
+// observe counts the received packets of addresses and their delays
+func (c *queueCounters) observe(addresses ...Address) {
+	now := TimestampNow()
+
+	c.mu.Lock()
+	c.received += uint64(len(addresses))
+	for i := range addresses {
+		d := TimestampDuration(now - addresses[i].Timestamp)
+		if d > c.maxDelay {
+			c.maxDelay = d
+		}
+		c.lastDelay = d
+	}
+	if c.lastRecv != 0 {
+		if interval := TimestampDuration(now - c.lastRecv); interval > 0 {
+			rate := float64(len(addresses)) / interval.Seconds()
+			c.rate += rateWeight * (rate - c.rate)
+		}
+	}
+	c.lastRecv = now
+	c.mu.Unlock()
+}
+
+// QueueStats returns the limits of the queue, and the packets received and
+// their delays as measured by Recv, RecvEx and RecvTimeout
+func (h *Handle) QueueStats() (QueueStats, error) {
+	stats := QueueStats{}
+
+	for _, v := range []struct {
+		p Param
+		v *uint64
+	}{{QueueLength, &stats.Length}, {QueueSize, &stats.Size}} {
+		value, err := h.GetParam(v.p)
+		if err != nil {
+			return stats, err
+		}
+		*v.v = value
+	}
+
+	d, err := h.GetQueueTime()
+	if err != nil {
+		return stats, err
+	}
+	stats.Time = d
+
+	h.counters.mu.Lock()
+	stats.Received = h.counters.received
+	stats.MaxDelay = h.counters.maxDelay
+	h.counters.maxDelay = 0
+	h.counters.mu.Unlock()
+
+	return stats, nil
+}
+
+// QueueOccupancy returns an estimate of the number of packets in the queue,
+// as the driver does not count them. By Little's law, it is the rate of
+// receiving times the delay of the last received packet, which holds while
+// the application keeps up with the rate of packets, and it is capped by
+// QueueLength. The estimate is 0 before two receives, and a value near
+// QueueLength means the queue is full and packets are dropped.
+func (h *Handle) QueueOccupancy() (uint64, error) {
+	length, err := h.GetParam(QueueLength)
+	if err != nil {
+		return 0, err
+	}
+
+	h.counters.mu.Lock()
+	n := uint64(h.counters.rate * h.counters.lastDelay.Seconds())
+	h.counters.mu.Unlock()
+
+	if n > length {
+		n = length
+	}
+	return n, nil
+}
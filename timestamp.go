@@ -0,0 +1,83 @@
+// +build windows
+
+package divert
+
+import (
+	"time"
+	"unsafe"
+)
+
+var (
+	procQueryPerformanceCounter   = modKernel32.NewProc("QueryPerformanceCounter")
+	procQueryPerformanceFrequency = modKernel32.NewProc("QueryPerformanceFrequency")
+)
+
+// qpcFreq is the frequency of the performance counter, which is fixed at
+// boot and is read once
+var qpcFreq = func() int64 {
+	freq := int64(0)
+	procQueryPerformanceFrequency.Call(uintptr(unsafe.Pointer(&freq)))
+	return freq
+}()
+
+// TimestampFrequency returns the frequency of the performance counter in
+// ticks per second. Address.Timestamp is a value of the counter, as returned
+// by QueryPerformanceCounter, when the driver received the packet or the
+// event.
+func TimestampFrequency() int64 {
+	return qpcFreq
+}
+
+// TimestampNow returns the value of the performance counter, which is the
+// clock of Address.Timestamp
+func TimestampNow() int64 {
+	v := int64(0)
+	procQueryPerformanceCounter.Call(uintptr(unsafe.Pointer(&v)))
+	return v
+}
+
+// TimestampDuration converts ticks of the performance counter to a duration,
+// such as the difference of two timestamps
+func TimestampDuration(ticks int64) time.Duration {
+	freq := qpcFreq
+	if freq == 0 {
+		return 0
+	}
+	return time.Duration(ticks/freq)*time.Second + time.Duration(ticks%freq)*time.Second/time.Duration(freq)
+}
+
+// TimestampTime returns the wall clock time of a timestamp of the performance
+// counter, by its age against TimestampNow
+func TimestampTime(ts int64) time.Time {
+	return time.Now().Add(-TimestampDuration(TimestampNow() - ts))
+}
+
+// Time returns the wall clock time of the timestamp of the address
+func (a *Address) Time() time.Time {
+	return TimestampTime(a.Timestamp)
+}
+
+// TimedPacket is a packet received by RecvTimed, with its address and the
+// time the driver received it. Unlike CapturedPacket, Data is a slice of the
+// buffer passed to RecvTimed rather than a copy, and Time is from the
+// timestamp of the address rather than the time of the receive.
+type TimedPacket struct {
+	Data    []byte
+	Address Address
+	Time    time.Time
+}
+
+// RecvTimed receives a packet into buffer, and returns it with its address
+// and the time of its timestamp
+func (h *Handle) RecvTimed(buffer []byte) (TimedPacket, error) {
+	p := TimedPacket{}
+
+	n, err := h.Recv(buffer, &p.Address)
+	if err != nil {
+		return p, err
+	}
+
+	p.Data = buffer[:n]
+	p.Time = p.Address.Time()
+	return p, nil
+}
@@ -0,0 +1,35 @@
+// +build windows
+
+package divert
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampDuration(t *testing.T) {
+	defer func(freq int64) { qpcFreq = freq }(qpcFreq)
+
+	for _, c := range []struct {
+		ticks int64
+		freq  int64
+		want  time.Duration
+	}{
+		{0, 10_000_000, 0},
+		{1, 10_000_000, 100 * time.Nanosecond},
+		{15_000_000, 10_000_000, 1500 * time.Millisecond},
+		{-5_000_000, 10_000_000, -500 * time.Millisecond},
+		{7, 3, 2333333333 * time.Nanosecond},
+		// a century of ticks overflows ticks*1e9, but not the duration
+		{100 * 365 * 86400 * 10_000_000, 10_000_000, 100 * 365 * 24 * time.Hour},
+		{-(1<<50 + 3), 10_000_000, -112589990684262700 * time.Nanosecond},
+		{1<<53 | 7, 3_000_000_000, 3002399751580333 * time.Nanosecond},
+		{9_000_000_000_000_000, 1_000_000_000, 9_000_000_000_000_000 * time.Nanosecond},
+		{1 << 40, 0, 0},
+	} {
+		qpcFreq = c.freq
+		if got := TimestampDuration(c.ticks); got != c.want {
+			t.Errorf("TimestampDuration(%v) at %v Hz is %v, want %v", c.ticks, c.freq, got, c.want)
+		}
+	}
+}